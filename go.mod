@@ -4,7 +4,6 @@ go 1.23.4
 
 require (
 	github.com/arthurkushman/go-hungarian v0.0.0-20210331201642-2b0c3bc2fb3f
-	github.com/hybridgroup/mjpeg v0.0.0-20140228234708-4680f319790e
 	github.com/ivanlebron/mjpeg-go v0.0.0-20230313091709-a9c60d8a6b2b
 	github.com/lmittmann/tint v1.0.7
 	github.com/muesli/gamut v0.3.1
@@ -19,6 +19,73 @@ const (
 	ModelFormatCaffe    = "caffe"
 )
 
+type DecoderType string
+
+const (
+	DecoderTypeYoloV5  = "v5"
+	DecoderTypeYoloV7  = "v7"
+	DecoderTypeYoloV8  = "v8"
+	DecoderTypeYoloV10 = "v10"
+	DecoderTypeSSD     = "ssd"
+)
+
+type DetectorType string
+
+const (
+	DetectorTypeDNN    = "dnn"
+	DetectorTypeReplay = "replay"
+)
+
+type ReplayFormat string
+
+const (
+	ReplayFormatNDJSON = "ndjson"
+	ReplayFormatMOT    = "mot"
+)
+
+type PaddingType string
+
+const (
+	PaddingTypeLetterbox = "letterbox"
+	PaddingTypeCrop      = "crop"
+	PaddingTypeResize    = "resize"
+)
+
+type MetricType string
+
+const (
+	MetricTypeCosine    = "cosine"
+	MetricTypeEuclidean = "euclidean"
+)
+
+type MotionModelType string
+
+const (
+	MotionModelTypeVelocity     = "velocity"
+	MotionModelTypeAcceleration = "acceleration"
+)
+
+type AggregationType string
+
+const (
+	AggregationTypeMean = "mean"
+	AggregationTypeMax  = "max"
+)
+
+type MaskMode string
+
+const (
+	MaskModeFill   = "fill"
+	MaskModeFilter = "filter"
+)
+
+type MaskAnchor string
+
+const (
+	MaskAnchorFoot = "foot"
+	MaskAnchorArea = "area"
+)
+
 type LoggingLevel string
 
 const (
@@ -47,16 +114,35 @@ const (
 // Config file structure
 
 type ConfigFile struct {
-	Yolo      YoloConfig
-	Reid      ReidConfig
-	Kalman    KalmanConfig
-	Backend   BackendConfig
-	Webserver WebserverConfig
-	Logging   LoggingConfig
-	Input     InputConfig
-	Mqtt      MqttConfig
-	Mask      MaskConfig
-	Crop      CropConfig
+	Yolo        YoloConfig
+	Reid        ReidConfig
+	Kalman      KalmanConfig
+	Sorter      SorterConfig
+	Backend     BackendConfig
+	Webserver   WebserverConfig
+	Logging     LoggingConfig
+	Input       InputConfig
+	Mqtt        MqttConfig
+	Mask        MaskConfig
+	Crop        CropConfig
+	Calibration CalibrationConfig
+	Zone        []ZoneConfig
+	Line        []LineConfig
+	Heatmap     HeatmapConfig
+	Camera      []CameraConfig
+}
+
+// Single camera pipeline: every camera gets its own input, sorter
+// and tracker while sharing the detectors with the others
+type CameraConfig struct {
+	Id          string `toml:"id" comment:"unique letters, digits, _ and -, defaults to the camera's index"`
+	Input       InputConfig
+	Crop        CropConfig
+	Mask        MaskConfig
+	Calibration CalibrationConfig
+	Zone        []ZoneConfig
+	Line        []LineConfig
+	TopicName   string `toml:"topic_name" comment:"defaults to mqtt topic_name"`
 }
 
 type CropConfig struct {
@@ -65,8 +151,12 @@ type CropConfig struct {
 }
 
 type MaskConfig struct {
+	Mode     MaskMode `toml:"mode" comment:"fill paints the contours over the frame, filter leaves the frame alone and drops the detections in them"`
 	Contours []Contour
 	Color    Color
+	Include  []Contour  `toml:"include" comment:"filter only: detections outside all of these are dropped too, empty for the whole frame"`
+	Anchor   MaskAnchor `toml:"anchor" comment:"filter only: foot to test the bottom center of the box, area for a share of the box"`
+	Fraction float64    `toml:"fraction" comment:"filter only: share of the box masked to drop it, area anchor only"`
 }
 
 type Color struct {
@@ -79,57 +169,127 @@ type Point struct {
 	X, Y uint
 }
 
+// Image points of the cropped frame and where they are on the floor
+// plane. Four or more of them, no three on a line, for positions in
+// meters
+type CalibrationConfig struct {
+	Points []CalibrationPoint
+}
+
+type CalibrationPoint struct {
+	Image Point
+	World WorldPoint `comment:"meters"`
+}
+
+type WorldPoint struct {
+	X, Y float64
+}
+
+// Polygon of the cropped frame to count the people standing in
+type ZoneConfig struct {
+	Name      string  `toml:"name" comment:"unique among the zones, defaults to zone and the index"`
+	Points    Contour `toml:"points" comment:"3 or more"`
+	LoiterSec float64 `toml:"loiter_sec" comment:"alert when a person stays longer, 0 for no alerts"`
+}
+
+// Segment of the cropped frame to count people crossing. Side a is
+// on the left looking from start to end, side b on the right
+type LineConfig struct {
+	Name  string `toml:"name" comment:"unique among the lines, defaults to line and the index"`
+	Start Point  `toml:"start"`
+	End   Point  `toml:"end"`
+}
+
 type MqttConfig struct {
-	Address   string `toml:"address"`
-	Port      uint   `toml:"port" comment:"usually 1883"`
-	TopicName string `toml:"topic_name"`
-	ClientID  string `toml:"client_id"`
-	Username  string `toml:"username"`
-	Password  string `toml:"password"`
-	Type      string `toml:"type"`
-	Subject   string `toml:"subject"`
+	Address         string `toml:"address"`
+	Port            uint   `toml:"port" comment:"usually 1883"`
+	TopicName       string `toml:"topic_name"`
+	ClientID        string `toml:"client_id"`
+	Username        string `toml:"username"`
+	Password        string `toml:"password"`
+	Type            string `toml:"type"`
+	Subject         string `toml:"subject"`
+	EventsTopicName string `toml:"events_topic_name" comment:"created, validated, lost, recovered, out of bounds and expired tracks, empty to not publish them"`
+	EventsSubject   string `toml:"events_subject"`
 }
 
 type ReidConfig struct {
-	Format            string  `toml:"format" comment:"onnx, openvino or caffe"`
-	Path              string  `toml:"path"`
-	ConfigPath        string  `toml:"config_path" comment:"required for caffe models"`
-	OutputLayerName   string  `toml:"output_layer_name" comment:"usually 'reid_embedding'"`
-	SMAWindow         uint    `toml:"sma_window" comment:"higher values for smoother trajectory at the cost of higher delay"`
-	TotalDescriptors  uint    `toml:"total_descriptors" comment:"higher values improve reidentification at the cost of performance"`
-	PredictSec        float64 `toml:"predict_sec" comment:"stops trying to predict the person's movement after specified time"`
-	ValidateSec       float64 `toml:"validate_sec" comment:"higher values filter out false positives at the cost of higher delay when discovering new people"`
-	ExpireSec         float64 `toml:"expire_sec" comment:"expire tracked people after specified time"`
-	NonValidExpireSec float64 `toml:"nonvalid_expire_sec" comment:"expire unvalidated people after specified time"`
-	ValidationFrames  uint    `toml:"validation_frames" comment:"minimum frames to detect before validation_duration to validate"`
-	ScoreThreshold    float64 `toml:"score_threshold" comment:"minimum score to associate people"`
-	DistanceFactor    float64 `toml:"distance_factor" comment:"divide distances above threshold"`
-	DistanceThreshold uint    `toml:"distance_threshold" comment:"distance after which the factor is applied to score"`
-	TokenLength       uint    `toml:"token_length" comment:"only affects log readability really"`
+	Enabled           bool      `toml:"enabled" comment:"false to track by motion and box overlap only, without a reid model"`
+	Format            string    `toml:"format" comment:"onnx, openvino or caffe"`
+	Path              string    `toml:"path"`
+	ConfigPath        string    `toml:"config_path" comment:"required for caffe models"`
+	OutputLayerName   string    `toml:"output_layer_name" comment:"usually 'reid_embedding'"`
+	Backend           string    `toml:"backend" comment:"openvino, opencv, cuda or vulkan, falls back to openvino/cpu and opencv/cpu"`
+	Target            string    `toml:"target" comment:"cpu, fp32, fp16, vpu, cuda..., defaults to backend device"`
+	W                 uint      `toml:"w" comment:"model input width, 0 to read from the onnx model"`
+	H                 uint      `toml:"h" comment:"model input height, 0 to read from the onnx model"`
+	ScaleFactor       float64   `toml:"scale_factor" comment:"pixel values are divided by it, 255 for models expecting 0..1"`
+	Mean              []float64 `toml:"mean" comment:"subtracted from the scaled values, in the model's channel order"`
+	Std               []float64 `toml:"std" comment:"divides the values after the mean is subtracted"`
+	SwapRB            bool      `toml:"swap_rb" comment:"set true for models expecting RGB"`
+	Padding           string    `toml:"padding" comment:"letterbox, crop or resize"`
+	SMAWindow         uint      `toml:"sma_window" comment:"higher values for smoother trajectory at the cost of higher delay"`
+	TotalDescriptors  uint      `toml:"total_descriptors" comment:"higher values improve reidentification at the cost of performance"`
+	BatchSize         uint      `toml:"batch_size" comment:"crops per forward pass, 0 for every crop of a frame at once, 1 for models with a fixed batch dimension"`
+	PredictSec        float64   `toml:"predict_sec" comment:"stops trying to predict the person's movement after specified time"`
+	ValidateSec       float64   `toml:"validate_sec" comment:"higher values filter out false positives at the cost of higher delay when discovering new people"`
+	ExpireSec         float64   `toml:"expire_sec" comment:"expire tracked people after specified time"`
+	NonValidExpireSec float64   `toml:"nonvalid_expire_sec" comment:"expire unvalidated people after specified time"`
+	ValidationFrames  uint      `toml:"validation_frames" comment:"minimum frames to detect before validation_duration to validate"`
+	BoundsMargin      uint      `toml:"bounds_margin" comment:"lost people predicted closer than this to the frame edge go out of bounds"`
+	OOBExpireSec      float64   `toml:"oob_expire_sec" comment:"expire people out of bounds after specified time"`
+	SummaryPoints     uint      `toml:"summary_points" comment:"most points of the path exported when a valid person expires, 0 to not export it"`
+	Metric            string    `toml:"metric" comment:"cosine or euclidean appearance similarity"`
+	Aggregation       string    `toml:"aggregation" comment:"mean or max similarity over the person's stored descriptors"`
+	ScoreThreshold    float64   `toml:"score_threshold" comment:"minimum score to associate people"`
+	AppearanceWeight  float64   `toml:"appearance_weight" comment:"weight of the reid similarity in the association score"`
+	IoUWeight         float64   `toml:"iou_weight" comment:"weight of the overlap of the predicted and detected boxes"`
+	MotionWeight      float64   `toml:"motion_weight" comment:"weight of the closeness to the predicted position"`
+	LowIoUThreshold   float64   `toml:"low_iou_threshold" comment:"minimum overlap with the predicted box to continue a track with a low confidence detection"`
+	Gate              float64   `toml:"gate" comment:"squared mahalanobis distance from the predicted position beyond which pairs never match, 0 to disable"`
+	TokenLength       uint      `toml:"token_length" comment:"only affects log readability really"`
 }
 
 type KalmanConfig struct {
-	ProcessNoiseCov float64 `toml:"process_noise_cov"`
+	Model               MotionModelType `toml:"model"`
+	ProcessNoiseDensity float64         `toml:"process_noise_density" comment:"px²/s³ for velocity, px²/s⁵ for acceleration"`
+	// per step variance of the OpenCV filter, rejected so that old
+	// configs don't silently end up with a rigid filter
+	ProcessNoiseCov float64 `toml:"process_noise_cov,omitempty"`
 	MeasNoiseCov    float64 `toml:"measurement_noise_cov"`
 }
 
 type YoloConfig struct {
-	Format              string  `toml:"format" comment:"onnx, openvino or caffe"`
-	Path                string  `toml:"path"`
-	ConfigPath          string  `toml:"config_path" comment:"required for caffe models"`
-	Transpose           bool    `toml:"transpose" comment:"set true for ultralythics-authored models"`
-	ScaleFactor         float64 `toml:"scale_factor"`
-	W                   uint    `toml:"w"`
-	H                   uint    `toml:"h"`
-	ConfidenceThreshold float32 `toml:"confidence_threshold"`
-	NMSThreshold        float32 `toml:"nms_threshold" comment:"lower values for more aggressive filtering"`
-	PersonClassIndex    uint    `toml:"person_class_index" comment:"0 or 1 for the majority of pre-trained models"`
-	Threads             uint    `toml:"threads" comment:"higher values increase performance on multicore systems"`
-	SortingFPS          uint    `toml:"sorting_fps" comment:"frequency of post-yolo sorter output"`
+	Detector               string   `toml:"detector" comment:"dnn or replay, replay plays back detections from replay_path instead of running the model"`
+	ReplayPath             string   `toml:"replay_path"`
+	ReplayFormat           string   `toml:"replay_format" comment:"ndjson or mot"`
+	Format                 string   `toml:"format" comment:"onnx, openvino or caffe"`
+	Path                   string   `toml:"path"`
+	ConfigPath             string   `toml:"config_path" comment:"required for caffe models"`
+	Backend                string   `toml:"backend" comment:"openvino, opencv, cuda or vulkan, falls back to openvino/cpu and opencv/cpu"`
+	Target                 string   `toml:"target" comment:"cpu, fp32, fp16, vpu, cuda..., defaults to backend device"`
+	Decoder                string   `toml:"decoder" comment:"output layout: v5, v7, v8, v10 or ssd, empty for the legacy v8-like layout"`
+	Transpose              bool     `toml:"transpose" comment:"only for the legacy layout, set true for ultralythics-authored models"`
+	ScaleFactor            float64  `toml:"scale_factor"`
+	W                      uint     `toml:"w"`
+	H                      uint     `toml:"h"`
+	ConfidenceThreshold    float32  `toml:"confidence_threshold"`
+	LowConfidenceThreshold float32  `toml:"low_confidence_threshold" comment:"detections between this and confidence_threshold only continue existing tracks, 0 to disable"`
+	NMSThreshold           float32  `toml:"nms_threshold" comment:"lower values for more aggressive filtering"`
+	PersonClassIndex       uint     `toml:"person_class_index" comment:"0 or 1 for the majority of pre-trained models, ignored if classes are set"`
+	Classes                []string `toml:"classes" comment:"names or indices of the classes to track"`
+	LabelsPath             string   `toml:"labels_path" comment:"class names, one per line"`
+	Labels                 []string `toml:"labels" comment:"class names, read from the model metadata or labels_path if empty"`
+	Threads                uint     `toml:"threads" comment:"higher values increase performance on multicore systems"`
+}
+
+type SorterConfig struct {
+	MaxLatencyMs uint `toml:"max_latency_ms" comment:"give up waiting for a missing frame after it's late by this much"`
+	Capacity     uint `toml:"capacity" comment:"max frames held while waiting for a missing one, 0 for no limit"`
 }
 
 type BackendConfig struct {
-	Device string `toml:"device" comment:"cpu, vpu or gpu"`
+	Device string `toml:"device" comment:"cpu, vpu or gpu, default target of the models"`
 }
 
 type InputConfig struct {
@@ -146,16 +306,72 @@ type WebserverConfig struct {
 	H                  uint `toml:"height" comment:"if either is zero - no resizing will be done"`
 }
 
+// Where the tracks walk, by camera
+type HeatmapConfig struct {
+	Enabled       bool    `toml:"enabled" comment:"served at /heatmap.png and /heatmap/<camera id>.png"`
+	WindowSec     float64 `toml:"window_sec" comment:"older traffic fades by e every window"`
+	CellSize      uint    `toml:"cell_size" comment:"px"`
+	Dir           string  `toml:"dir" comment:"saved here as <camera id>.heatmap and loaded on start, empty to not save"`
+	SavePeriodSec uint    `toml:"save_period_sec" comment:"0 to only save on shutdown"`
+}
+
 type LoggingConfig struct {
 	Level         string `toml:"level" comment:"debug, info, warn or error"`
 	StatPeriodSec uint   `toml:"stat_period_sec"`
 }
 
+// Returns the configured cameras. If there are none falls back to
+// the top level input, crop and mask sections as a single camera
+func (c *ConfigFile) Cameras() ([]CameraConfig, error) {
+	cameras := c.Camera
+	if len(cameras) == 0 {
+		cameras = []CameraConfig{{
+			Input:       c.Input,
+			Crop:        c.Crop,
+			Mask:        c.Mask,
+			Calibration: c.Calibration,
+			Zone:        c.Zone,
+			Line:        c.Line,
+		}}
+	}
+	ret := make([]CameraConfig, 0, len(cameras))
+	ids := make(map[string]bool, len(cameras))
+	for ind, camera := range cameras {
+		if camera.Id == "" {
+			camera.Id = fmt.Sprintf("%d", ind)
+		}
+		if !validId(camera.Id) {
+			return nil, fmt.Errorf("Camera id %q has characters other than letters, digits, _ and -", camera.Id)
+		}
+		if ids[camera.Id] {
+			return nil, fmt.Errorf("Duplicate camera id %s", camera.Id)
+		}
+		ids[camera.Id] = true
+		if camera.TopicName == "" {
+			camera.TopicName = c.Mqtt.TopicName
+		}
+		ret = append(ret, camera)
+	}
+	return ret, nil
+}
+
+// Camera ids end up in urls and mqtt topics as they are
+func validId(id string) bool {
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
 func Migrate(file_path string) error {
-	config_file, err := Unmarshal(file_path)
+	config_file, err := unmarshal(file_path)
 	if err != nil {
 		return err
 	}
+	// the old variance has no density equivalent, the default is kept
+	config_file.Kalman.ProcessNoiseCov = 0
 	new_path, err := getLegalIncrementedFileName(file_path)
 	if err != nil {
 		return err
@@ -183,37 +399,66 @@ func getLegalIncrementedFileName(file_path string) (string, error) {
 func CreateDefault(file_path string) error {
 	config_file := new(ConfigFile)
 	config_file.Reid = ReidConfig{
-		Format:            "onnx",
-		Path:              "/my/model.onnx",
-		ConfigPath:        "/my/config.xml",
-		OutputLayerName:   "reid_embedding",
-		SMAWindow:         5,
-		TotalDescriptors:  3,
-		PredictSec:        0.3,
-		ValidateSec:       1.0,
-		ExpireSec:         2.0,
-		DistanceFactor:    2,
-		DistanceThreshold: 150,
-		ScoreThreshold:    0.001,
-		ValidationFrames:  5,
-		TokenLength:       4,
+		Enabled:          true,
+		Format:           "onnx",
+		Path:             "/my/model.onnx",
+		ConfigPath:       "/my/config.xml",
+		OutputLayerName:  "reid_embedding",
+		Backend:          "openvino",
+		W:                128,
+		H:                256,
+		ScaleFactor:      1.0,
+		SwapRB:           false,
+		Padding:          "letterbox",
+		SMAWindow:        5,
+		TotalDescriptors: 3,
+		BatchSize:        16,
+		PredictSec:       0.3,
+		ValidateSec:      1.0,
+		ExpireSec:        2.0,
+		OOBExpireSec:     0.5,
+		SummaryPoints:    64,
+		BoundsMargin:     0,
+		AppearanceWeight: 0.7,
+		IoUWeight:        0.3,
+		MotionWeight:     0,
+		LowIoUThreshold:  0.5,
+		Gate:             9.21,
+		Metric:           "cosine",
+		Aggregation:      "max",
+		ScoreThreshold:   0.001,
+		ValidationFrames: 5,
+		TokenLength:      4,
 	}
 	config_file.Yolo = YoloConfig{
-		Format:              "onnx",
-		Path:                "/my/model.onnx",
-		ConfigPath:          "/my/config.xml",
-		Transpose:           false,
-		ScaleFactor:         255.0,
-		W:                   640,
-		H:                   480,
-		ConfidenceThreshold: 0.995,
-		NMSThreshold:        0.05,
-		PersonClassIndex:    1,
-		Threads:             3,
+		Detector:               "dnn",
+		ReplayPath:             "/my/detections.ndjson",
+		ReplayFormat:           "ndjson",
+		Format:                 "onnx",
+		Path:                   "/my/model.onnx",
+		ConfigPath:             "/my/config.xml",
+		Backend:                "openvino",
+		Decoder:                "v7",
+		Transpose:              false,
+		ScaleFactor:            255.0,
+		W:                      640,
+		H:                      480,
+		ConfidenceThreshold:    0.995,
+		LowConfidenceThreshold: 0.5,
+		NMSThreshold:           0.05,
+		PersonClassIndex:       0,
+		Classes:                []string{"person"},
+		LabelsPath:             "/my/labels.txt",
+		Threads:                3,
 	}
 	config_file.Kalman = KalmanConfig{
-		ProcessNoiseCov: 0.01,
-		MeasNoiseCov:    600,
+		Model:               MotionModelTypeVelocity,
+		ProcessNoiseDensity: 100,
+		MeasNoiseCov:        600,
+	}
+	config_file.Sorter = SorterConfig{
+		MaxLatencyMs: 500,
+		Capacity:     32,
 	}
 	config_file.Backend = BackendConfig{
 		Device: "cpu",
@@ -228,17 +473,26 @@ func CreateDefault(file_path string) error {
 		WriteTimeoutSec:    0,
 		ShutdownTimeoutSec: 3,
 	}
+	config_file.Heatmap = HeatmapConfig{
+		Enabled:       true,
+		WindowSec:     3600,
+		CellSize:      8,
+		Dir:           "",
+		SavePeriodSec: 60,
+	}
 	config_file.Logging = LoggingConfig{
 		Level:         "info",
 		StatPeriodSec: 4,
 	}
 	config_file.Mqtt = MqttConfig{
-		Address:   "127.0.0.1",
-		Port:      1883,
-		TopicName: "tracking",
-		ClientID:  "01",
-		Username:  "user",
-		Password:  "pass",
+		Address:         "127.0.0.1",
+		Port:            1883,
+		TopicName:       "tracking",
+		EventsTopicName: "tracking/events",
+		EventsSubject:   "lifecycle",
+		ClientID:        "01",
+		Username:        "user",
+		Password:        "pass",
 	}
 	return Write2File(config_file, file_path)
 }
@@ -261,7 +515,34 @@ func Write2File(config_file *ConfigFile, file_path string) error {
 }
 
 func Unmarshal(file_path string) (*ConfigFile, error) {
+	config_file, err := unmarshal(file_path)
+	if err != nil {
+		return nil, err
+	}
+	if config_file.Kalman.ProcessNoiseCov != 0 {
+		return nil, fmt.Errorf("%s: kalman process_noise_cov is replaced by process_noise_density, "+
+			"a spectral density in px²/s³ rather than a per frame variance, 100 is a good start, "+
+			"run with -migrate to switch to it", file_path)
+	}
+	return config_file, nil
+}
+
+// Unmarshal without rejecting the outdated options
+func unmarshal(file_path string) (*ConfigFile, error) {
 	config_file := new(ConfigFile)
+	// configs predating the option had reid always on
+	config_file.Reid.Enabled = true
+	config_file.Kalman.ProcessNoiseDensity = 100
+	// configs predating the sorter section
+	config_file.Sorter = SorterConfig{MaxLatencyMs: 500, Capacity: 32}
+	// configs predating the association weights had distance_factor
+	// and distance_threshold instead, weights of 0 would match on
+	// appearance only without any spatial constraint
+	config_file.Reid.AppearanceWeight = 0.7
+	config_file.Reid.IoUWeight = 0.3
+	config_file.Reid.Gate = 9.21
+	// configs predating the option embedded one crop at a time
+	config_file.Reid.BatchSize = 1
 	data, err := os.ReadFile(file_path)
 	if err != nil {
 		return nil,
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/pelletier/go-toml/v2"
@@ -22,3 +24,201 @@ func TestCreate(t *testing.T) {
 		t.Fatalf("Can't create empty config: %s", err)
 	}
 }
+
+func TestCameras(t *testing.T) {
+	cfg := new(ConfigFile)
+	err := toml.Unmarshal([]byte(`
+[mqtt]
+topic_name = "tracking"
+
+[[camera]]
+id = "entrance"
+topic_name = "entrance"
+[camera.input]
+type = "file"
+path = "/a.mp4"
+[camera.crop]
+a = { x = 10, y = 10 }
+b = { x = 100, y = 100 }
+
+[[camera]]
+[camera.input]
+type = "ipc"
+path = "rtsp://b"
+[camera.mask]
+contours = [[{ x = 1, y = 2 }, { x = 30, y = 2 }, { x = 30, y = 40 }]]
+`), cfg)
+	if err != nil {
+		t.Fatalf("Can't unmarshal: %s", err)
+	}
+	cameras, err := cfg.Cameras()
+	if err != nil {
+		t.Fatalf("Can't enumerate cameras: %s", err)
+	}
+	if len(cameras) != 2 {
+		t.Fatalf("Expected 2 cameras, got %d", len(cameras))
+	}
+	if cameras[0].Id != "entrance" || cameras[0].TopicName != "entrance" || cameras[0].Crop.B.X != 100 {
+		t.Fatalf("Bad first camera: %+v", cameras[0])
+	}
+	if cameras[1].Id != "1" || cameras[1].TopicName != "tracking" || cameras[1].Input.Path != "rtsp://b" ||
+		len(cameras[1].Mask.Contours) != 1 {
+		t.Fatalf("Bad second camera: %+v", cameras[1])
+	}
+
+	cfg.Camera[1].Id = "entrance"
+	if _, err := cfg.Cameras(); err == nil {
+		t.Fatalf("Expected duplicate ids to be rejected")
+	}
+
+	for _, id := range []string{"front door", "cam{1}", "a/b"} {
+		cfg.Camera[1].Id = id
+		if _, err := cfg.Cameras(); err == nil {
+			t.Fatalf("Expected id %q to be rejected", id)
+		}
+	}
+
+	legacy := &ConfigFile{Input: InputConfig{Type: "file", Path: "/c.mp4"}}
+	cameras, err = legacy.Cameras()
+	if err != nil || len(cameras) != 1 || cameras[0].Input.Path != "/c.mp4" {
+		t.Fatalf("Expected the top level input as a single camera, got %+v (%v)", cameras, err)
+	}
+}
+
+func TestReidEnabledByDefault(t *testing.T) {
+	for data, expected := range map[string]bool{
+		"[reid]\npath = \"/reid.onnx\"\n": true,
+		"[reid]\nenabled = false\n":       false,
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("Can't write config: %s", err)
+		}
+		cfg, err := Unmarshal(path)
+		if err != nil {
+			t.Fatalf("Can't unmarshal: %s", err)
+		}
+		if cfg.Reid.Enabled != expected {
+			t.Fatalf("Expected enabled %v for %q", expected, data)
+		}
+	}
+}
+
+func TestSorterDefaults(t *testing.T) {
+	for data, expected := range map[string]SorterConfig{
+		"[kalman]\nmeasurement_noise_cov = 600\n": {MaxLatencyMs: 500, Capacity: 32},
+		"[sorter]\nmax_latency_ms = 100\n":        {MaxLatencyMs: 100, Capacity: 32},
+		"[sorter]\ncapacity = 0\n":                {MaxLatencyMs: 500, Capacity: 0},
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("Can't write config: %s", err)
+		}
+		cfg, err := Unmarshal(path)
+		if err != nil {
+			t.Fatalf("Can't unmarshal: %s", err)
+		}
+		if cfg.Sorter != expected {
+			t.Fatalf("Expected %+v for %q, got %+v", expected, data, cfg.Sorter)
+		}
+	}
+}
+
+func TestAssociationDefaults(t *testing.T) {
+	cases := []struct {
+		data                  string
+		appearance, iou, gate float64
+	}{
+		{"[reid]\ndistance_factor = 45\n", 0.7, 0.3, 9.21},
+		{"[reid]\nappearance_weight = 0\niou_weight = 1\ngate = 0\n", 0, 1, 0},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(c.data), 0o644); err != nil {
+			t.Fatalf("Can't write config: %s", err)
+		}
+		cfg, err := Unmarshal(path)
+		if err != nil {
+			t.Fatalf("Can't unmarshal: %s", err)
+		}
+		if cfg.Reid.AppearanceWeight != c.appearance || cfg.Reid.IoUWeight != c.iou || cfg.Reid.Gate != c.gate {
+			t.Fatalf("Expected weights %v, %v and gate %v for %q, got %+v", c.appearance, c.iou, c.gate, c.data, cfg.Reid)
+		}
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	for data, expected := range map[string]uint{
+		"[reid]\npath = \"/reid.xml\"\n": 1,
+		"[reid]\nbatch_size = 0\n":       0,
+		"[reid]\nbatch_size = 16\n":      16,
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("Can't write config: %s", err)
+		}
+		cfg, err := Unmarshal(path)
+		if err != nil {
+			t.Fatalf("Can't unmarshal: %s", err)
+		}
+		if cfg.Reid.BatchSize != expected {
+			t.Fatalf("Expected batch size %d for %q, got %d", expected, data, cfg.Reid.BatchSize)
+		}
+	}
+}
+
+func TestProcessNoise(t *testing.T) {
+	for data, expected := range map[string]float64{
+		"[kalman]\nmeasurement_noise_cov = 600\n": 100,
+		"[kalman]\nprocess_noise_density = 20\n":  20,
+		"[kalman]\nprocess_noise_cov = 0.01\n":    0,
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("Can't write config: %s", err)
+		}
+		cfg, err := Unmarshal(path)
+		if expected == 0 {
+			if err == nil {
+				t.Fatalf("Expected %q to be rejected", data)
+			}
+			if err := Migrate(path); err != nil {
+				t.Fatalf("Can't migrate: %s", err)
+			}
+			if cfg, err := Unmarshal(path); err != nil || cfg.Kalman.ProcessNoiseDensity != 100 {
+				t.Fatalf("Expected the default density after migrating, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Can't unmarshal: %s", err)
+		}
+		if cfg.Kalman.ProcessNoiseDensity != expected {
+			t.Fatalf("Expected density %v for %q, got %v", expected, data, cfg.Kalman.ProcessNoiseDensity)
+		}
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	cfg := new(ConfigFile)
+	err := toml.Unmarshal([]byte(`
+[calibration]
+points = [
+  { image = { x = 200, y = 100 }, world = { x = 0.0, y = 6.5 } },
+  { image = { x = 440, y = 100 }, world = { x = 4, y = 6.5 } },
+  { image = { x = 640, y = 480 }, world = { x = 4, y = 0 } },
+  { image = { x = 0, y = 480 }, world = { x = 0, y = 0 } },
+]
+`), cfg)
+	if err != nil {
+		t.Fatalf("Can't unmarshal: %s", err)
+	}
+	cameras, err := cfg.Cameras()
+	if err != nil {
+		t.Fatalf("Can't enumerate cameras: %s", err)
+	}
+	points := cameras[0].Calibration.Points
+	if len(points) != 4 || points[1].Image != (Point{440, 100}) || points[1].World != (WorldPoint{4, 6.5}) {
+		t.Fatalf("Bad calibration: %+v", points)
+	}
+}
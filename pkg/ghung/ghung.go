@@ -2,40 +2,146 @@ package ghung
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/Robogera/detect/pkg/gmat"
 	"github.com/Robogera/detect/pkg/seq"
 )
 
-func Solve[T seq.Float](m *gmat.Mat[T]) map[int]map[int]T {
-	perms := [][]int{
-		{0, 1, 2},
-		{0, 2, 1},
-		{1, 0, 2},
-		{1, 2, 0},
-		{2, 0, 1},
-		{2, 1, 0},
-	}
-	var min_sum T
-	var min_perm []int
-	set := false
-	for _, perm := range perms {
-		var sum T
-		for r, c := range perm {
-			sum += m.At(r, c)
-		}
-		if sum < min_sum || !set {
-			min_sum = sum
-			min_perm = perm
-			set = true
-		}
-	}
-	ass := make(map[int]map[int]T)
-	for r, c := range min_perm {
-		ass[r] = make(map[int]T)
-		ass[r][c] = m.At(r,c)
-	}
-	return ass
+// Column assigned to every row of a cost matrix, Unassigned for rows
+// left without one
+type Assignment []int
+
+const Unassigned = -1
+
+// Assigns every row of the rows×cols cost matrix m a distinct column
+// so that as many rows as possible get one and the total cost is
+// minimal. Cells costing +Inf are never assigned, NaN counts as +Inf
+func Solve[T seq.Float](m *gmat.Mat[T]) Assignment {
+	rows, cols := m.Size(gmat.Vertical), m.Size(gmat.Horizontal)
+	assignment := make(Assignment, rows)
+	for row := range assignment {
+		assignment[row] = Unassigned
+	}
+	if rows == 0 || cols == 0 {
+		return assignment
+	}
+
+	// forbidden cells cost more than any set of allowed ones, so the
+	// cheapest assignment uses as few of them as it can
+	var total float64
+	for row := range rows {
+		for col := range cols {
+			if cost := float64(m.At(row, col)); !isForbidden(cost) {
+				total += math.Abs(cost)
+			}
+		}
+	}
+	forbidden := 2*total + 1
+	cost := func(row, col int) float64 {
+		value := float64(m.At(row, col))
+		if isForbidden(value) {
+			return forbidden
+		}
+		return value
+	}
+
+	if rows <= cols {
+		for row, col := range shortestPaths(rows, cols, cost) {
+			if !isForbidden(float64(m.At(row, col))) {
+				assignment[row] = col
+			}
+		}
+		return assignment
+	}
+	// the algorithm needs at least as many columns as rows
+	transposed := func(row, col int) float64 { return cost(col, row) }
+	for col, row := range shortestPaths(cols, rows, transposed) {
+		if !isForbidden(float64(m.At(row, col))) {
+			assignment[row] = col
+		}
+	}
+	return assignment
+}
+
+func isForbidden(cost float64) bool {
+	return math.IsInf(cost, 1) || math.IsNaN(cost)
+}
+
+// Hungarian algorithm with row and column potentials, adding rows
+// one by one along the shortest augmenting path. Needs rows <= cols
+// and finite costs, returns the column of every row
+func shortestPaths(rows, cols int, cost func(row, col int) float64) []int {
+	// 1-based with row 0 and column 0 as the virtual start of every
+	// augmenting path
+	u := make([]float64, rows+1)
+	v := make([]float64, cols+1)
+	// row assigned to the column
+	p := make([]int, cols+1)
+	// previous column on the path
+	way := make([]int, cols+1)
+	for row := 1; row <= rows; row++ {
+		p[0] = row
+		col0 := 0
+		minv := make([]float64, cols+1)
+		used := make([]bool, cols+1)
+		for col := range minv {
+			minv[col] = math.Inf(1)
+		}
+		for p[col0] != 0 {
+			used[col0] = true
+			row0 := p[col0]
+			delta := math.Inf(1)
+			col1 := 0
+			for col := 1; col <= cols; col++ {
+				if used[col] {
+					continue
+				}
+				reduced := cost(row0-1, col-1) - u[row0] - v[col]
+				if reduced < minv[col] {
+					minv[col] = reduced
+					way[col] = col0
+				}
+				if minv[col] < delta {
+					delta = minv[col]
+					col1 = col
+				}
+			}
+			for col := range cols + 1 {
+				if used[col] {
+					u[p[col]] += delta
+					v[col] -= delta
+				} else {
+					minv[col] -= delta
+				}
+			}
+			col0 = col1
+		}
+		// flip the path
+		for col0 != 0 {
+			col1 := way[col0]
+			p[col0] = p[col1]
+			col0 = col1
+		}
+	}
+	assignment := make([]int, rows)
+	for col := 1; col <= cols; col++ {
+		if p[col] != 0 {
+			assignment[p[col]-1] = col - 1
+		}
+	}
+	return assignment
+}
+
+// Total cost of the assigned cells
+func Cost[T seq.Float](m *gmat.Mat[T], assignment Assignment) T {
+	var sum T
+	for row, col := range assignment {
+		if col != Unassigned {
+			sum += m.At(row, col)
+		}
+	}
+	return sum
 }
 
 func subtractMin[T seq.Float](m *gmat.Mat[T], d gmat.Direction) {
@@ -1,6 +1,7 @@
 package ghung
 
 import (
+	"math"
 	"math/rand/v2"
 	"testing"
 
@@ -31,3 +32,101 @@ func TestSanity(t *testing.T) {
 	t.Logf("2d:\n%v\n", m.To2d())
 	t.Logf("m:\n%v\n", s)
 }
+
+// Most assigned rows with the least total cost over all
+// assignments of rows to distinct allowed columns
+func bruteForce(m *gmat.Mat[float64]) (int, float64) {
+	rows, cols := m.Size(gmat.Vertical), m.Size(gmat.Horizontal)
+	best_count, best_cost := 0, 0.0
+	used := make([]bool, cols)
+	var walk func(row, count int, cost float64)
+	walk = func(row, count int, cost float64) {
+		if row == rows {
+			if count > best_count || (count == best_count && cost < best_cost) {
+				best_count, best_cost = count, cost
+			}
+			return
+		}
+		walk(row+1, count, cost)
+		for col := range cols {
+			if used[col] || math.IsInf(m.At(row, col), 1) {
+				continue
+			}
+			used[col] = true
+			walk(row+1, count+1, cost+m.At(row, col))
+			used[col] = false
+		}
+	}
+	walk(0, 0, 0)
+	return best_count, best_cost
+}
+
+func TestSolve(t *testing.T) {
+	cases := []struct {
+		rows, cols int
+		// share of forbidden cells
+		forbidden float64
+	}{
+		{1, 1, 0},
+		{3, 3, 0},
+		{4, 4, 0.3},
+		{2, 5, 0},
+		{5, 2, 0},
+		{3, 6, 0.5},
+		{6, 3, 0.5},
+		{5, 5, 0.8},
+		{6, 6, 1},
+	}
+	for _, c := range cases {
+		for range 50 {
+			m := gmat.NewMat[float64](c.rows, c.cols)
+			for r := range c.rows {
+				for col := range c.cols {
+					if rand.Float64() < c.forbidden {
+						m.Set(r, col, math.Inf(1))
+					} else {
+						m.Set(r, col, rand.Float64()*200-100)
+					}
+				}
+			}
+			assignment := Solve(m)
+			if len(assignment) != c.rows {
+				t.Fatalf("Expected %d rows assigned, got %v", c.rows, assignment)
+			}
+			count, seen := 0, make(map[int]bool)
+			for row, col := range assignment {
+				if col == Unassigned {
+					continue
+				}
+				if seen[col] {
+					t.Fatalf("Column %d assigned twice in %v", col, assignment)
+				}
+				if math.IsInf(m.At(row, col), 1) {
+					t.Fatalf("Forbidden cell (%d, %d) assigned", row, col)
+				}
+				seen[col] = true
+				count++
+			}
+			expected_count, expected_cost := bruteForce(m)
+			if cost := Cost(m, assignment); count != expected_count || math.Abs(cost-expected_cost) > 1e-9 {
+				t.Fatalf("%dx%d:\n%s\nexpected %d assigned for %f, got %d for %f: %v",
+					c.rows, c.cols, m.Sprintf("%.2f"), expected_count, expected_cost, count, cost, assignment)
+			}
+		}
+	}
+}
+
+func TestSolveEmpty(t *testing.T) {
+	for _, size := range [][2]int{{0, 0}, {0, 3}, {3, 0}} {
+		m := gmat.NewMat[float32](size[0], size[1])
+		assignment := Solve(m)
+		if len(assignment) != size[0] {
+			t.Fatalf("%v: expected %d rows, got %v", size, size[0], assignment)
+		}
+		for _, col := range assignment {
+			if col != Unassigned {
+				t.Fatalf("%v: unexpected assignment %v", size, assignment)
+			}
+		}
+	}
+}
@@ -24,6 +24,8 @@ const (
 type Mat[T any] struct {
 	s      []T
 	stride int
+	// kept for matrices without columns
+	rows int
 }
 
 // Vector backed by the data of the
@@ -40,7 +42,7 @@ func (m Mat[T]) At(r, c int) T {
 
 func (m Mat[T]) Size(direction Direction) int {
 	if direction == Vertical {
-		return len(m.s) / m.stride
+		return m.rows
 	}
 	return m.stride
 }
@@ -115,6 +117,7 @@ func NewMat[T any](r, c int) *Mat[T] {
 	return &Mat[T]{
 		s:      make([]T, r*c),
 		stride: c,
+		rows:   r,
 	}
 }
 
@@ -123,6 +126,7 @@ func Map[T, E any](m *Mat[T], f func(e T, r, c int) E) *Mat[E] {
 	new_mat := &Mat[E]{
 		s:      make([]E, len(m.s)),
 		stride: m.stride,
+		rows:   m.rows,
 	}
 	for ind_r, vec := range m.Vectors(false) {
 		for ind_c, value := range vec.All() {
@@ -0,0 +1,117 @@
+package gocvcommon
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/Robogera/detect/pkg/config"
+	"gocv.io/x/gocv"
+)
+
+var (
+	ERR_BACKEND = errors.New("Unknown backend")
+	ERR_TARGET  = errors.New("Unknown target")
+	ERR_FORMAT  = errors.New("Unknown model format")
+	ERR_EMPTY   = errors.New("Empty model")
+)
+
+type BackendTarget struct {
+	Backend gocv.NetBackendType
+	Target  gocv.NetTargetType
+}
+
+func (bt BackendTarget) String() string {
+	backend := bt.Backend.String()
+	if backend == "" {
+		backend = "default"
+	}
+	return backend + "/" + bt.Target.String()
+}
+
+// Parses backend and target names. An empty target is taken
+// from the device
+func ParseBackendTarget(backend, target, device string) (BackendTarget, error) {
+	var bt BackendTarget
+	switch backend = strings.ToLower(backend); backend {
+	case "", "default":
+		bt.Backend = gocv.NetBackendDefault
+	case "openvino", "opencv", "cuda", "vulkan", "halide":
+		bt.Backend = gocv.ParseNetBackend(backend)
+	default:
+		return bt, fmt.Errorf("%w: %s", ERR_BACKEND, backend)
+	}
+	if target == "" {
+		switch config.DeviceType(strings.ToLower(device)) {
+		case config.DeviceTypeGPU:
+			target = "fp32"
+		case config.DeviceTypeVPU:
+			target = "vpu"
+		default:
+			target = "cpu"
+		}
+	}
+	switch target = strings.ToLower(target); target {
+	case "cpu", "fp32", "fp16", "vpu", "vulkan", "fpga", "cuda", "cudafp16":
+		bt.Target = gocv.ParseNetTarget(target)
+	default:
+		return bt, fmt.Errorf("%w: %s", ERR_TARGET, target)
+	}
+	return bt, nil
+}
+
+// Preferred backend followed by the ones that work
+// (more or less) everywhere
+func FallbackChain(preferred BackendTarget) []BackendTarget {
+	chain := []BackendTarget{preferred}
+	for _, fallback := range []BackendTarget{
+		{gocv.NetBackendOpenVINO, gocv.NetTargetCPU},
+		{gocv.NetBackendOpenCV, gocv.NetTargetCPU},
+	} {
+		if fallback != preferred {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+func ReadNet(format, path, config_path string) (gocv.Net, error) {
+	var net gocv.Net
+	// TODO: panic and recover when the CGO segfaults maybe?
+	switch config.ModelFormat(format) {
+	case config.ModelFormatCaffe:
+		// TODO: test
+		net = gocv.ReadNetFromCaffe(config_path, path)
+	case config.ModelFormatONNX:
+		net = gocv.ReadNetFromONNX(path)
+	case config.ModelFormatOpenVINO:
+		// TODO: test
+		net = gocv.ReadNet(path, config_path)
+	default:
+		return net, fmt.Errorf("%w: %s", ERR_FORMAT, format)
+	}
+	if net.Empty() {
+		net.Close()
+		return net, fmt.Errorf("%w: %s", ERR_EMPTY, path)
+	}
+	return net, nil
+}
+
+func SetBackendTarget(net *gocv.Net, bt BackendTarget) error {
+	if err := net.SetPreferableBackend(bt.Backend); err != nil {
+		return err
+	}
+	return net.SetPreferableTarget(bt.Target)
+}
+
+// Runs a single forward pass on a blank NCHW blob of size.
+// An unsupported backend makes OpenCV abort the whole process
+// so only call this in a process that can afford it
+func Probe(net *gocv.Net, size image.Point) {
+	blob := gocv.NewMatWithSizesWithScalar([]int{1, 3, size.Y, size.X}, gocv.MatTypeCV32F, gocv.NewScalar(0, 0, 0, 0))
+	defer blob.Close()
+	net.SetInput(blob, "")
+	output := net.Forward("")
+	output.Close()
+}
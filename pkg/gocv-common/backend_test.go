@@ -0,0 +1,44 @@
+package gocvcommon
+
+import (
+	"errors"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+func TestParseBackendTarget(t *testing.T) {
+	for _, c := range []struct {
+		backend, target, device string
+		want                    BackendTarget
+	}{
+		{"openvino", "", "cpu", BackendTarget{gocv.NetBackendOpenVINO, gocv.NetTargetCPU}},
+		{"OpenVINO", "", "vpu", BackendTarget{gocv.NetBackendOpenVINO, gocv.NetTargetVPU}},
+		{"openvino", "", "gpu", BackendTarget{gocv.NetBackendOpenVINO, gocv.NetTargetFP32}},
+		{"opencv", "fp16", "vpu", BackendTarget{gocv.NetBackendOpenCV, gocv.NetTargetFP16}},
+		{"", "", "", BackendTarget{gocv.NetBackendDefault, gocv.NetTargetCPU}},
+	} {
+		got, err := ParseBackendTarget(c.backend, c.target, c.device)
+		if err != nil || got != c.want {
+			t.Fatalf("%s/%s on %s: expected %s, got %s (%v)", c.backend, c.target, c.device, c.want, got, err)
+		}
+	}
+	if _, err := ParseBackendTarget("tensorrt", "", "cpu"); !errors.Is(err, ERR_BACKEND) {
+		t.Fatalf("Expected an unknown backend error, got %v", err)
+	}
+	if _, err := ParseBackendTarget("opencv", "tpu", "cpu"); !errors.Is(err, ERR_TARGET) {
+		t.Fatalf("Expected an unknown target error, got %v", err)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	chain := FallbackChain(BackendTarget{gocv.NetBackendOpenVINO, gocv.NetTargetVPU})
+	if len(chain) != 3 || chain[0].String() != "openvino/vpu" ||
+		chain[1].String() != "openvino/cpu" || chain[2].String() != "opencv/cpu" {
+		t.Fatalf("Unexpected chain %v", chain)
+	}
+	chain = FallbackChain(BackendTarget{gocv.NetBackendOpenVINO, gocv.NetTargetCPU})
+	if len(chain) != 2 || chain[1].String() != "opencv/cpu" {
+		t.Fatalf("Expected no duplicates, got %v", chain)
+	}
+}
@@ -0,0 +1,208 @@
+package heatmap
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ERR_SIZE = errors.New("Bad heatmap size")
+	ERR_FILE = errors.New("Bad heatmap file")
+)
+
+// Foot points of the tracks counted on a grid of cells. Counts fade
+// by e every window so old traffic stops showing after a few windows.
+// Safe to use from several goroutines
+type Heatmap struct {
+	mu     sync.Mutex
+	cell   int
+	window time.Duration
+	// of the frame the grid covers
+	size       image.Point
+	cols, rows int
+	values     []float64
+	// values are faded up to this moment
+	updated time.Time
+}
+
+// What's kept on disk
+type saved struct {
+	Cell    int
+	Size    image.Point
+	Values  []float64
+	Updated time.Time
+}
+
+// Empty heatmap of cell by cell pixel squares
+func New(cell int, window time.Duration) (*Heatmap, error) {
+	if cell <= 0 {
+		return nil, fmt.Errorf("%w: cell of %d px", ERR_SIZE, cell)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("%w: window of %s", ERR_SIZE, window)
+	}
+	return &Heatmap{cell: cell, window: window}, nil
+}
+
+// Drops the counts and covers a frame of the new size
+func (h *Heatmap) reset(size image.Point) {
+	h.size = size
+	h.cols = (size.X + h.cell - 1) / h.cell
+	h.rows = (size.Y + h.cell - 1) / h.cell
+	h.values = make([]float64, h.cols*h.rows)
+}
+
+func (h *Heatmap) fade(t time.Time) {
+	if !t.After(h.updated) {
+		return
+	}
+	if !h.updated.IsZero() {
+		k := math.Exp(-float64(t.Sub(h.updated)) / float64(h.window))
+		for i := range h.values {
+			h.values[i] *= k
+		}
+	}
+	h.updated = t
+}
+
+// Counts the points seen at t in a frame of the given size, points
+// outside the frame are skipped. A frame of another size starts the
+// heatmap over
+func (h *Heatmap) Add(t time.Time, size image.Point, points []image.Point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if size != h.size {
+		h.reset(size)
+	}
+	h.fade(t)
+	bounds := image.Rectangle{Max: size}
+	for _, p := range points {
+		if !p.In(bounds) {
+			continue
+		}
+		h.values[(p.Y/h.cell)*h.cols+p.X/h.cell]++
+	}
+}
+
+// Faded count of the cell holding p
+func (h *Heatmap) At(p image.Point) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !p.In(image.Rectangle{Max: h.size}) {
+		return 0
+	}
+	return h.values[(p.Y/h.cell)*h.cols+p.X/h.cell]
+}
+
+// Size of the frame the heatmap covers, zero before the first Add
+func (h *Heatmap) Size() image.Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.size
+}
+
+// Heatmap stretched over an image of the given size, transparent
+// where nobody has been and blue to red, more opaque, with the count
+// relative to the busiest cell
+func (h *Heatmap) Image(size image.Point) *image.NRGBA {
+	img := image.NewNRGBA(image.Rectangle{Max: size})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var peak float64
+	for _, v := range h.values {
+		peak = max(peak, v)
+	}
+	if peak == 0 || size.X <= 0 || size.Y <= 0 {
+		return img
+	}
+	for y := range size.Y {
+		row := (y * h.size.Y / size.Y) / h.cell
+		for x := range size.X {
+			col := (x * h.size.X / size.X) / h.cell
+			v := h.values[row*h.cols+col]
+			if v == 0 {
+				continue
+			}
+			// square root so the quiet paths still show next to the
+			// busy ones
+			img.SetNRGBA(x, y, colorize(math.Sqrt(v/peak)))
+		}
+	}
+	return img
+}
+
+// Jet colormap for 0..1 with the alpha rising along with it
+func colorize(v float64) color.NRGBA {
+	channel := func(center float64) uint8 {
+		return uint8(255 * math.Max(0, math.Min(1, 1.5-math.Abs(4*v-center))))
+	}
+	return color.NRGBA{
+		R: channel(3),
+		G: channel(2),
+		B: channel(1),
+		A: uint8(64 + 191*v),
+	}
+}
+
+// Writes the heatmap to path through a temporary file so a crash
+// midway leaves the previous one intact
+func (h *Heatmap) Save(path string) error {
+	h.mu.Lock()
+	state := saved{
+		Cell:    h.cell,
+		Size:    h.size,
+		Values:  append([]float64(nil), h.values...),
+		Updated: h.updated,
+	}
+	h.mu.Unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("Can't create a file next to %s: %w", path, err)
+	}
+	defer os.Remove(file.Name())
+	if err := gob.NewEncoder(file).Encode(state); err != nil {
+		file.Close()
+		return fmt.Errorf("Can't write %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Can't write %s: %w", file.Name(), err)
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Replaces the counts with the ones saved at path. They keep fading
+// from the moment they were saved
+func (h *Heatmap) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var state saved
+	if err := gob.NewDecoder(file).Decode(&state); err != nil {
+		return fmt.Errorf("%w: %s: %w", ERR_FILE, path, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if state.Cell != h.cell {
+		return fmt.Errorf("%w: %s has cells of %d px, expected %d", ERR_FILE, path, state.Cell, h.cell)
+	}
+	h.reset(state.Size)
+	if len(state.Values) != len(h.values) {
+		h.reset(image.Point{})
+		return fmt.Errorf("%w: %s has %d cells for %v", ERR_FILE, path, len(state.Values), state.Size)
+	}
+	copy(h.values, state.Values)
+	h.updated = state.Updated
+	return nil
+}
@@ -0,0 +1,110 @@
+package heatmap
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdd(t *testing.T) {
+	h, err := New(10, time.Minute)
+	if err != nil {
+		t.Fatalf("Can't create: %s", err)
+	}
+	start := time.Unix(0, 0)
+	size := image.Pt(95, 45)
+	h.Add(start, size, []image.Point{{0, 0}, {9, 9}, {94, 44}, {95, 44}, {-1, 0}})
+	cases := []struct {
+		p        image.Point
+		expected float64
+	}{
+		{image.Pt(5, 5), 2},
+		{image.Pt(90, 40), 1},
+		{image.Pt(15, 5), 0},
+		{image.Pt(100, 5), 0},
+	}
+	for _, c := range cases {
+		if v := h.At(c.p); v != c.expected {
+			t.Fatalf("%v: expected %f, got %f", c.p, c.expected, v)
+		}
+	}
+
+	// faded by e every window
+	h.Add(start.Add(time.Minute), size, nil)
+	if v := h.At(image.Pt(5, 5)); math.Abs(v-2/math.E) > 1e-9 {
+		t.Fatalf("Expected %f after a window, got %f", 2/math.E, v)
+	}
+	// a frame of another size starts over
+	h.Add(start.Add(2*time.Minute), image.Pt(50, 50), nil)
+	if v := h.At(image.Pt(5, 5)); v != 0 || h.Size() != image.Pt(50, 50) {
+		t.Fatalf("Expected an empty 50x50 heatmap, got %f in %v", v, h.Size())
+	}
+}
+
+func TestImage(t *testing.T) {
+	h, _ := New(10, time.Minute)
+	if img := h.Image(image.Pt(20, 20)); img.NRGBAAt(5, 5).A != 0 {
+		t.Fatalf("Expected a transparent image before any points")
+	}
+	start := time.Unix(0, 0)
+	h.Add(start, image.Pt(40, 20), []image.Point{{5, 5}, {5, 5}, {5, 5}, {5, 5}, {35, 15}})
+	// twice the size of the frame
+	img := h.Image(image.Pt(80, 40))
+	if img.Bounds().Size() != image.Pt(80, 40) {
+		t.Fatalf("Expected 80x40, got %v", img.Bounds().Size())
+	}
+	busy, quiet, empty := img.NRGBAAt(10, 10), img.NRGBAAt(70, 30), img.NRGBAAt(30, 10)
+	if busy.R < busy.B || busy.A != 255 {
+		t.Fatalf("Expected an opaque red busy cell, got %v", busy)
+	}
+	if quiet.G <= busy.G || quiet.A == 0 || quiet.A >= busy.A {
+		t.Fatalf("Expected a cooler translucent quiet cell, got %v", quiet)
+	}
+	if empty.A != 0 {
+		t.Fatalf("Expected a transparent empty cell, got %v", empty)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.heatmap")
+	start := time.Unix(0, 0)
+	h, _ := New(10, time.Minute)
+	h.Add(start, image.Pt(40, 20), []image.Point{{5, 5}, {35, 15}})
+	if err := h.Save(path); err != nil {
+		t.Fatalf("Can't save: %s", err)
+	}
+
+	loaded, _ := New(10, time.Minute)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Can't load: %s", err)
+	}
+	if loaded.Size() != image.Pt(40, 20) || loaded.At(image.Pt(35, 15)) != 1 {
+		t.Fatalf("Expected the saved counts, got %f in %v", loaded.At(image.Pt(35, 15)), loaded.Size())
+	}
+	// the time since saving counts
+	loaded.Add(start.Add(time.Minute), image.Pt(40, 20), nil)
+	if v := loaded.At(image.Pt(35, 15)); math.Abs(v-1/math.E) > 1e-9 {
+		t.Fatalf("Expected %f a window after saving, got %f", 1/math.E, v)
+	}
+
+	other, _ := New(20, time.Minute)
+	if err := other.Load(path); !errors.Is(err, ERR_FILE) {
+		t.Fatalf("Expected %v for another cell size, got %v", ERR_FILE, err)
+	}
+	if err := other.Load(path + ".missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %v, got %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(0, time.Minute); !errors.Is(err, ERR_SIZE) {
+		t.Fatalf("Expected %v for no cell size, got %v", ERR_SIZE, err)
+	}
+	if _, err := New(10, 0); !errors.Is(err, ERR_SIZE) {
+		t.Fatalf("Expected %v for no window, got %v", ERR_SIZE, err)
+	}
+}
@@ -0,0 +1,148 @@
+package homography
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	ERR_POINTS     = errors.New("Not enough point correspondences")
+	ERR_DEGENERATE = errors.New("Degenerate point correspondences")
+)
+
+// Singular values below this share of the largest one count as 0
+const rank_tolerance = 1e-9
+
+type Point struct {
+	X, Y float64
+}
+
+// Projective mapping of the image plane onto the floor plane
+type Homography struct {
+	h *mat.Dense
+	// of the calibration points, points with the other sign are
+	// beyond the horizon
+	w_sign float64
+}
+
+// Estimates the homography mapping every image point to the world
+// point with the same index in the least squares sense. Needs four
+// correspondences at least, no three of them on a line
+func Estimate(image, world []Point) (*Homography, error) {
+	if len(image) != len(world) {
+		return nil, fmt.Errorf("%w: %d image points and %d world points", ERR_POINTS, len(image), len(world))
+	}
+	if len(image) < 4 {
+		return nil, fmt.Errorf("%w: %d, need 4", ERR_POINTS, len(image))
+	}
+
+	// conditioning the points keeps the singular values apart
+	image_norm, image_points := normalize(image)
+	world_norm, world_points := normalize(world)
+
+	a := mat.NewDense(max(2*len(image), 9), 9, nil)
+	for i := range image_points {
+		x, y := image_points[i].X, image_points[i].Y
+		u, v := world_points[i].X, world_points[i].Y
+		a.SetRow(2*i, []float64{x, y, 1, 0, 0, 0, -u * x, -u * y, -u})
+		a.SetRow(2*i+1, []float64{0, 0, 0, x, y, 1, -v * x, -v * y, -v})
+	}
+
+	var svd mat.SVD
+	if !svd.Factorize(a, mat.SVDFull) {
+		return nil, fmt.Errorf("%w: SVD failed", ERR_DEGENERATE)
+	}
+	values := svd.Values(nil)
+	// the solution has to be unique up to scale
+	if values[7] < rank_tolerance*values[0] {
+		return nil, ERR_DEGENERATE
+	}
+	var v mat.Dense
+	svd.VTo(&v)
+	normalized := mat.NewDense(3, 3, mat.Col(nil, 8, &v))
+
+	// H = world_norm^-1 * normalized * image_norm
+	var world_inv mat.Dense
+	if err := world_inv.Inverse(world_norm); err != nil {
+		return nil, fmt.Errorf("%w: %w", ERR_DEGENERATE, err)
+	}
+	h := mat.NewDense(3, 3, nil)
+	h.Product(&world_inv, normalized, image_norm)
+	if math.Abs(mat.Det(h)) < rank_tolerance*math.Pow(mat.Norm(h, 2), 3) {
+		return nil, ERR_DEGENERATE
+	}
+
+	homography := &Homography{h: h}
+	homography.w_sign = math.Copysign(1, homography.w(image[0]))
+	for _, p := range image {
+		if math.Copysign(1, homography.w(p)) != homography.w_sign {
+			return nil, fmt.Errorf("%w: points on both sides of the horizon", ERR_DEGENERATE)
+		}
+	}
+	return homography, nil
+}
+
+// Similarity moving the centroid of points to the origin and their
+// mean distance from it to √2, and the transformed points
+func normalize(points []Point) (*mat.Dense, []Point) {
+	var cx, cy float64
+	for _, p := range points {
+		cx += p.X
+		cy += p.Y
+	}
+	cx /= float64(len(points))
+	cy /= float64(len(points))
+	var mean_dist float64
+	for _, p := range points {
+		mean_dist += math.Hypot(p.X-cx, p.Y-cy)
+	}
+	mean_dist /= float64(len(points))
+	scale := 1.0
+	if mean_dist > 0 {
+		scale = math.Sqrt2 / mean_dist
+	}
+	normalized := make([]Point, len(points))
+	for i, p := range points {
+		normalized[i] = Point{(p.X - cx) * scale, (p.Y - cy) * scale}
+	}
+	return mat.NewDense(3, 3, []float64{
+		scale, 0, -cx * scale,
+		0, scale, -cy * scale,
+		0, 0, 1,
+	}), normalized
+}
+
+func (h *Homography) w(p Point) float64 {
+	return h.h.At(2, 0)*p.X + h.h.At(2, 1)*p.Y + h.h.At(2, 2)
+}
+
+// World point p maps to, false for points on or beyond the horizon
+func (h *Homography) Project(p Point) (Point, bool) {
+	w := h.w(p)
+	if w == 0 || math.Copysign(1, w) != h.w_sign {
+		return Point{}, false
+	}
+	return Point{
+		(h.h.At(0, 0)*p.X + h.h.At(0, 1)*p.Y + h.h.At(0, 2)) / w,
+		(h.h.At(1, 0)*p.X + h.h.At(1, 1)*p.Y + h.h.At(1, 2)) / w,
+	}, true
+}
+
+// World position of p and the world velocity of a point moving
+// through p at v per second
+func (h *Homography) ProjectMotion(p, v Point) (Point, Point, bool) {
+	// a short step keeps the perspective from bending the velocity
+	const dt = 0.01
+	position, ok := h.Project(p)
+	if !ok {
+		return Point{}, Point{}, false
+	}
+	next, ok := h.Project(Point{p.X + v.X*dt, p.Y + v.Y*dt})
+	if !ok {
+		return Point{}, Point{}, false
+	}
+	return position, Point{(next.X - position.X) / dt, (next.Y - position.Y) / dt}, true
+}
@@ -0,0 +1,157 @@
+package homography
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func near(a, b Point, tolerance float64) bool {
+	return math.Abs(a.X-b.X) <= tolerance && math.Abs(a.Y-b.Y) <= tolerance
+}
+
+func TestProjection(t *testing.T) {
+	cases := []struct {
+		name         string
+		image, world []Point
+		// image points and where they are expected to land
+		checks [][2]Point
+	}{
+		{
+			name:  "scale",
+			image: []Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}},
+			world: []Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+			checks: [][2]Point{
+				{{50, 50}, {0.5, 0.5}},
+				{{200, 300}, {2, 3}},
+			},
+		},
+		{
+			name:  "flip and shift",
+			image: []Point{{0, 0}, {640, 0}, {640, 480}, {0, 480}},
+			world: []Point{{10, 5}, {10, -1.4}, {5.2, -1.4}, {5.2, 5}},
+			checks: [][2]Point{
+				{{320, 240}, {7.6, 1.8}},
+				{{0, 240}, {7.6, 5}},
+			},
+		},
+		{
+			// a 4x6m floor patch seen from the side, the far edge is
+			// shorter in the image
+			name:  "perspective",
+			image: []Point{{200, 100}, {440, 100}, {640, 480}, {0, 480}},
+			world: []Point{{0, 6}, {4, 6}, {4, 0}, {0, 0}},
+			checks: [][2]Point{
+				// the middle of the far edge
+				{{320, 100}, {2, 6}},
+				// the middle of the near edge
+				{{320, 480}, {2, 0}},
+				// the diagonals cross in the center of the patch,
+				// which is closer to the far edge in the image
+				{{320, 100 + 380*120.0/440}, {2, 3}},
+			},
+		},
+		{
+			name: "overdetermined",
+			image: []Point{
+				{200, 100}, {440, 100}, {640, 480}, {0, 480},
+				{320, 100}, {320, 480},
+			},
+			world: []Point{
+				{0, 6}, {4, 6}, {4, 0}, {0, 0},
+				{2, 6}, {2, 0},
+			},
+			checks: [][2]Point{
+				{{320, 100 + 380*120.0/440}, {2, 3}},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, err := Estimate(c.image, c.world)
+			if err != nil {
+				t.Fatalf("Can't estimate: %s", err)
+			}
+			for i := range c.image {
+				if p, ok := h.Project(c.image[i]); !ok || !near(p, c.world[i], 1e-6) {
+					t.Fatalf("Calibration point %v: expected %v, got %v, %t", c.image[i], c.world[i], p, ok)
+				}
+			}
+			for _, check := range c.checks {
+				if p, ok := h.Project(check[0]); !ok || !near(p, check[1], 1e-6) {
+					t.Fatalf("%v: expected %v, got %v, %t", check[0], check[1], p, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestHorizon(t *testing.T) {
+	h, err := Estimate(
+		[]Point{{200, 100}, {440, 100}, {640, 480}, {0, 480}},
+		[]Point{{0, 6}, {4, 6}, {4, 0}, {0, 0}},
+	)
+	if err != nil {
+		t.Fatalf("Can't estimate: %s", err)
+	}
+	// the sides of the patch meet at y = 480 - 380*320/200
+	horizon := 480 - 380*320.0/200
+	if _, ok := h.Project(Point{320, horizon - 10}); ok {
+		t.Fatalf("Point above the horizon projected")
+	}
+	if _, ok := h.Project(Point{320, horizon + 10}); !ok {
+		t.Fatalf("Point below the horizon not projected")
+	}
+}
+
+func TestMotion(t *testing.T) {
+	h, err := Estimate(
+		[]Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}},
+		[]Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+	)
+	if err != nil {
+		t.Fatalf("Can't estimate: %s", err)
+	}
+	position, velocity, ok := h.ProjectMotion(Point{50, 50}, Point{30, -40})
+	if !ok || !near(position, Point{0.5, 0.5}, 1e-9) || !near(velocity, Point{0.3, -0.4}, 1e-9) {
+		t.Fatalf("Expected 0.3,-0.4 m/s at 0.5,0.5, got %v m/s at %v", velocity, position)
+	}
+}
+
+func TestBadCorrespondences(t *testing.T) {
+	cases := []struct {
+		name         string
+		image, world []Point
+		err          error
+	}{
+		{
+			name:  "too few",
+			image: []Point{{0, 0}, {1, 0}, {1, 1}},
+			world: []Point{{0, 0}, {1, 0}, {1, 1}},
+			err:   ERR_POINTS,
+		},
+		{
+			name:  "mismatched",
+			image: []Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+			world: []Point{{0, 0}, {1, 0}, {1, 1}},
+			err:   ERR_POINTS,
+		},
+		{
+			name:  "collinear",
+			image: []Point{{0, 0}, {1, 1}, {2, 2}, {3, 3}},
+			world: []Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+			err:   ERR_DEGENERATE,
+		},
+		{
+			name:  "repeated",
+			image: []Point{{0, 0}, {0, 0}, {1, 1}, {0, 1}},
+			world: []Point{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+			err:   ERR_DEGENERATE,
+		},
+	}
+	for _, c := range cases {
+		if _, err := Estimate(c.image, c.world); !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
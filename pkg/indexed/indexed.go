@@ -2,18 +2,23 @@ package indexed
 
 import "time"
 
+// Value tagged with the source it came from (a camera id for example)
+// and its index within that source. Indices of different sources
+// are not comparable
 type Indexed[T any] struct {
-	t     time.Time
-	id    uint64
-	value T
+	t      time.Time
+	source string
+	id     uint64
+	value  T
 }
 
-func NewIndexed[T any](id uint64, t time.Time, value T) Indexed[T] {
-	return Indexed[T]{t, id, value}
+func NewIndexed[T any](source string, id uint64, t time.Time, value T) Indexed[T] {
+	return Indexed[T]{t, source, id, value}
 }
 
 func (i Indexed[T]) Less(other Indexed[T]) bool { return i.id < other.id }
 func (i Indexed[T]) Id() uint64                 { return i.id }
+func (i Indexed[T]) Source() string             { return i.source }
 func (i Indexed[T]) Time() time.Time            { return i.t }
 func (i Indexed[T]) Value() T                   { return i.value }
 
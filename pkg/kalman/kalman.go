@@ -2,121 +2,256 @@ package kalman
 
 import (
 	"image"
+	"math"
 	"time"
 
-	"gocv.io/x/gocv"
+	"gonum.org/v1/gonum/mat"
 )
 
-func set(s [][]float32, m gocv.Mat) {
-	for r_ind, r := range s {
-		for c_ind, v := range r {
-			m.SetFloatAt(r_ind, c_ind, v)
+// px²/s², a walking person in a 1080p frame easily does 100px/s
+const initial_speed_var = 1e4
+
+// px²/s⁴
+const initial_acceleration_var = 1e4
+
+// Measured values: box center, width and height
+const meas_dim = 4
+
+// Number of derivatives the state holds for every measured value,
+// the values themselves included
+type Model int
+
+const (
+	ModelConstantVelocity     Model = 2
+	ModelConstantAcceleration Model = 3
+)
+
+// Tracks a box as its center, width and height. The state is laid
+// out as cx, cy, w, h followed by their velocities and, for the
+// acceleration model, accelerations
+type Filter struct {
+	model Model
+	x     *mat.VecDense
+	p     *mat.SymDense
+	h     *mat.Dense
+	r     *mat.SymDense
+	// spectral density of the white noise driving the highest
+	// derivative
+	q           float64
+	last_update time.Time
+}
+
+func NewFilter(box image.Rectangle, t time.Time, model Model, proc_noise_density, meas_noise_cov float64) *Filter {
+	n := meas_dim * int(model)
+	x := mat.NewVecDense(n, nil)
+	x.SetVec(0, float64(box.Min.X+box.Max.X)/2)
+	x.SetVec(1, float64(box.Min.Y+box.Max.Y)/2)
+	x.SetVec(2, float64(box.Dx()))
+	x.SetVec(3, float64(box.Dy()))
+
+	// the first measurement is as good as any, the derivatives are
+	// unknown until the next ones
+	p := mat.NewSymDense(n, nil)
+	h := mat.NewDense(meas_dim, n, nil)
+	r := mat.NewSymDense(meas_dim, nil)
+	for i := range meas_dim {
+		p.SetSym(i, i, meas_noise_cov)
+		p.SetSym(meas_dim+i, meas_dim+i, initial_speed_var)
+		if model == ModelConstantAcceleration {
+			p.SetSym(2*meas_dim+i, 2*meas_dim+i, initial_acceleration_var)
+		}
+		h.Set(i, i, 1)
+		r.SetSym(i, i, meas_noise_cov)
+	}
+
+	return &Filter{
+		model:       model,
+		x:           x,
+		p:           p,
+		h:           h,
+		r:           r,
+		q:           proc_noise_density,
+		last_update: t,
+	}
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+// Taylor expansion of the state over dt
+func (kf *Filter) transition(dt float64) *mat.Dense {
+	order := int(kf.model)
+	n := meas_dim * order
+	f := mat.NewDense(n, n, nil)
+	for row := range order {
+		for col := row; col < order; col++ {
+			k := col - row
+			for i := range meas_dim {
+				f.Set(row*meas_dim+i, col*meas_dim+i, math.Pow(dt, float64(k))/factorial(k))
+			}
 		}
 	}
+	return f
 }
 
-type Filter struct {
-	filter      *gocv.KalmanFilter
-	last_update time.Time
+// Integral of the white noise driving the highest derivative over dt
+func (kf *Filter) processNoise(dt float64) *mat.SymDense {
+	order := int(kf.model)
+	n := meas_dim * order
+	q := mat.NewSymDense(n, nil)
+	for row := range order {
+		for col := row; col < order; col++ {
+			power := 2*order - row - col - 1
+			value := kf.q * math.Pow(dt, float64(power)) /
+				(float64(power) * factorial(order-1-row) * factorial(order-1-col))
+			for i := range meas_dim {
+				q.SetSym(row*meas_dim+i, col*meas_dim+i, value)
+			}
+		}
+	}
+	return q
 }
 
-func (kf *Filter) Free() {
-	kf.filter.Close()
+// State and its covariance predicted for t
+func (kf *Filter) predicted(t time.Time) (*mat.VecDense, *mat.SymDense) {
+	dt := t.Sub(kf.last_update).Seconds()
+	if dt <= 0 {
+		return mat.VecDenseCopyOf(kf.x), kf.Covariance()
+	}
+	f := kf.transition(dt)
+	x := mat.NewVecDense(kf.x.Len(), nil)
+	x.MulVec(f, kf.x)
+
+	var fp, fpf mat.Dense
+	fp.Mul(f, kf.p)
+	fpf.Mul(&fp, f.T())
+	p := symmetric(&fpf)
+	p.AddSym(p, kf.processNoise(dt))
+	return x, p
 }
 
-func (kf *Filter) predict(dt float32) {
-	tr_mat := kf.filter.GetTransitionMatrix()
-	defer tr_mat.Close()
-	tr_mat.SetFloatAt(0, 2, dt)
-	tr_mat.SetFloatAt(1, 3, dt)
-	// tr_mat.SetFloatAt(0, 4, dt*dt/2)
-	// tr_mat.SetFloatAt(1, 5, dt*dt/2)
-	// tr_mat.SetFloatAt(2, 4, dt)
-	// tr_mat.SetFloatAt(3, 5, dt)
-	// tr_mat.SetFloatAt(4, 4, .8)
-	// tr_mat.SetFloatAt(5, 5, .8)
-	pred := kf.filter.Predict()
-	defer pred.Close()
+// H*P*H' + R
+func (kf *Filter) innovationCov(p *mat.SymDense) *mat.SymDense {
+	var hp, hph mat.Dense
+	hp.Mul(kf.h, p)
+	hph.Mul(&hp, kf.h.T())
+	s := symmetric(&hph)
+	s.AddSym(s, kf.r)
+	return s
 }
 
 func (kf *Filter) Predict(t time.Time) {
-	dt := float32(t.Sub(kf.last_update).Seconds())
-	kf.predict(dt)
+	kf.x, kf.p = kf.predicted(t)
+	kf.last_update = t
 }
 
-func (kf *Filter) Update(meas image.Point, t time.Time) {
-	dt := float32(t.Sub(kf.last_update).Seconds())
-	kf.predict(dt)
+func (kf *Filter) Update(box image.Rectangle, t time.Time) {
+	kf.Predict(t)
+
+	z := mat.NewVecDense(meas_dim, []float64{
+		float64(box.Min.X+box.Max.X) / 2,
+		float64(box.Min.Y+box.Max.Y) / 2,
+		float64(box.Dx()),
+		float64(box.Dy()),
+	})
+	var y mat.VecDense
+	y.MulVec(kf.h, kf.x)
+	y.SubVec(z, &y)
+
+	var chol mat.Cholesky
+	if !chol.Factorize(kf.innovationCov(kf.p)) {
+		return
+	}
+	// K' = S^-1*H*P, S and P being symmetric
+	var hp, kt mat.Dense
+	hp.Mul(kf.h, kf.p)
+	if err := chol.SolveTo(&kt, &hp); err != nil {
+		return
+	}
+
+	var ky mat.VecDense
+	ky.MulVec(kt.T(), &y)
+	kf.x.AddVec(kf.x, &ky)
 
-	meas_mat := gocv.NewMatWithSize(2, 1, gocv.MatTypeCV32F)
-	defer meas_mat.Close()
-	meas_mat.SetFloatAt(0, 0, float32(meas.X))
-	meas_mat.SetFloatAt(1, 0, float32(meas.Y))
-	corr := kf.filter.Correct(meas_mat)
-	defer corr.Close()
+	// P = P - K*H*P
+	var khp, p mat.Dense
+	khp.Mul(kt.T(), &hp)
+	p.Sub(kf.p, &khp)
+	kf.p = symmetric(&p)
 }
 
-func (kf *Filter) State() image.Point {
-	state := kf.filter.GetStatePost()
-	defer state.Close()
+// Squared Mahalanobis distance of a measured center at t from the
+// predicted one
+func (kf *Filter) SquaredMahalanobis(meas image.Point, t time.Time) float64 {
+	x, p := kf.predicted(t)
+	cov := kf.innovationCov(p)
+	dx, dy := float64(meas.X)-x.AtVec(0), float64(meas.Y)-x.AtVec(1)
+	det := cov.At(0, 0)*cov.At(1, 1) - cov.At(0, 1)*cov.At(1, 0)
+	if det <= 0 {
+		return math.Inf(1)
+	}
+	return (dx*dx*cov.At(1, 1) - 2*dx*dy*cov.At(0, 1) + dy*dy*cov.At(0, 0)) / det
+}
+
+// Box predicted for t. Doesn't change the filter
+func (kf *Filter) PredictBox(t time.Time) image.Rectangle {
+	x, _ := kf.predicted(t)
+	return toBox(x)
+}
 
-	return image.Pt(
-		int(state.GetFloatAt(0, 0)),
-		int(state.GetFloatAt(0, 1)),
-	)
+// Center of the box
+func (kf *Filter) State() image.Point {
+	return image.Pt(int(math.Round(kf.x.AtVec(0))), int(math.Round(kf.x.AtVec(1))))
 }
 
-func (kf *Filter) Noise() []float32 {
-	noise_cov := kf.filter.GetMeasurementNoiseCov()
-	defer noise_cov.Close()
+func (kf *Filter) Box() image.Rectangle {
+	return toBox(kf.x)
+}
 
-	data, _ := noise_cov.DataPtrFloat32()
-	raw_data := make([]float32, len(data))
-	copy(raw_data, data)
+// Velocity of the center in px/s
+func (kf *Filter) Speed() image.Point {
+	return image.Pt(int(math.Round(kf.x.AtVec(meas_dim))), int(math.Round(kf.x.AtVec(meas_dim+1))))
+}
 
-	return raw_data
+// Velocities of the center, width and height in px/s
+func (kf *Filter) Velocity() [meas_dim]float64 {
+	var v [meas_dim]float64
+	for i := range meas_dim {
+		v[i] = kf.x.AtVec(meas_dim + i)
+	}
+	return v
 }
 
-func (kf *Filter) Process() []float32 {
-	noise_cov := kf.filter.GetProcessNoiseCov()
-	defer noise_cov.Close()
+// Copy of the state covariance
+func (kf *Filter) Covariance() *mat.SymDense {
+	p := mat.NewSymDense(kf.p.SymmetricDim(), nil)
+	p.CopySym(kf.p)
+	return p
+}
 
-	data, _ := noise_cov.DataPtrFloat32()
-	raw_data := make([]float32, len(data))
-	copy(raw_data, data)
+func (kf *Filter) Model() Model {
+	return kf.model
+}
 
-	return raw_data
+func toBox(x *mat.VecDense) image.Rectangle {
+	w, h := max(1, x.AtVec(2)), max(1, x.AtVec(3))
+	min := image.Pt(int(math.Round(x.AtVec(0)-w/2)), int(math.Round(x.AtVec(1)-h/2)))
+	return image.Rectangle{min, min.Add(image.Pt(int(math.Round(w)), int(math.Round(h))))}
 }
 
-func (kf *Filter) Speed() image.Point {
-	state := kf.filter.GetStatePost()
-	defer state.Close()
-
-	return image.Pt(
-		int(state.GetFloatAt(0, 2)),
-		int(state.GetFloatAt(0, 3)),
-	)
-}
-
-func (kf *Filter) Close() {
-	kf.filter.Close()
-}
-
-func NewFilter(p image.Point, t time.Time, proc_noise_cov, meas_noise_cov float64) *Filter {
-	filter := gocv.NewKalmanFilter(4, 2)
-	gocv.SetIdentity(filter.GetTransitionMatrix(), 1)
-	gocv.SetIdentity(filter.GetMeasurementMatrix(), 1)
-	gocv.SetIdentity(filter.GetProcessNoiseCov(), proc_noise_cov)
-	gocv.SetIdentity(filter.GetMeasurementNoiseCov(), meas_noise_cov)
-	gocv.SetIdentity(filter.GetErrorCovPost(), 1)
-	mat := filter.GetStatePre()
-	mat.SetFloatAt(0, 0, float32(p.X))
-	mat.SetFloatAt(0, 1, float32(p.Y))
-	filter.SetStatePre(mat)
-	mat = filter.GetStatePost()
-	mat.SetFloatAt(0, 0, float32(p.X))
-	mat.SetFloatAt(0, 1, float32(p.Y))
-	filter.SetStatePost(mat)
-	return &Filter{
-		filter: &filter, last_update: t}
+// Rounding makes products like F*P*F' slightly asymmetric
+func symmetric(m *mat.Dense) *mat.SymDense {
+	n, _ := m.Dims()
+	s := mat.NewSymDense(n, nil)
+	for i := range n {
+		for j := i; j < n; j++ {
+			s.SetSym(i, j, (m.At(i, j)+m.At(j, i))/2)
+		}
+	}
+	return s
 }
@@ -1,95 +1,187 @@
 package kalman
 
 import (
-	"context"
 	"image"
-	"image/color"
 	"math"
-	"math/rand/v2"
-	"net/http"
 	"testing"
 	"time"
-
-	"github.com/Robogera/detect/pkg/gsma"
-	"github.com/hybridgroup/mjpeg"
-	"gocv.io/x/gocv"
 )
 
-func normSin(f, t float64) float64 { return (math.Sin(f*math.Pi*2*t)*0.8 + 1) / 2 }
-func normCos(f, t float64) float64 { return (math.Cos(f*math.Pi*2*t)*0.8 + 1) / 2 }
+const frame = time.Second / 30
 
-func toJpeg(img gocv.Mat) []byte {
-	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
-	if err != nil {
-		return nil
-	}
-	data := make([]byte, buf.Len())
-	copy(data, buf.GetBytes())
-	return data
+func boxAt(cx, cy, w, h float64) image.Rectangle {
+	min := image.Pt(int(math.Round(cx-w/2)), int(math.Round(cy-h/2)))
+	return image.Rectangle{min, min.Add(image.Pt(int(math.Round(w)), int(math.Round(h))))}
 }
 
-const W = 1920
-const H = 1080
-
-func TestSanity(t *testing.T) {
-	t0 := time.Now()
-	output_stream := mjpeg.NewStream()
+func TestTracking(t *testing.T) {
+	cases := []struct {
+		name  string
+		model Model
+		// center and size at s seconds
+		path func(s float64) (cx, cy, w, h float64)
+		// expected velocity of the center at the end
+		speed image.Point
+	}{
+		{
+			name:  "still",
+			model: ModelConstantVelocity,
+			path:  func(s float64) (float64, float64, float64, float64) { return 300, 200, 50, 120 },
+			speed: image.Pt(0, 0),
+		},
+		{
+			name:  "walking",
+			model: ModelConstantVelocity,
+			path: func(s float64) (float64, float64, float64, float64) {
+				return 100 + 90*s, 200 - 30*s, 50, 120
+			},
+			speed: image.Pt(90, -30),
+		},
+		{
+			name:  "approaching",
+			model: ModelConstantVelocity,
+			path: func(s float64) (float64, float64, float64, float64) {
+				return 300, 200 + 10*s, 50 + 10*s, 120 + 24*s
+			},
+			speed: image.Pt(0, 10),
+		},
+		{
+			name:  "walking with acceleration model",
+			model: ModelConstantAcceleration,
+			path: func(s float64) (float64, float64, float64, float64) {
+				return 100 + 90*s, 200 - 30*s, 50, 120
+			},
+			speed: image.Pt(90, -30),
+		},
+		{
+			name:  "speeding up",
+			model: ModelConstantAcceleration,
+			path: func(s float64) (float64, float64, float64, float64) {
+				return 100 + 20*s*s, 200, 50, 120
+			},
+			// 40px/s² for 3s
+			speed: image.Pt(120, 0),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Unix(0, 0)
+			filter := NewFilter(boxAt(c.path(0)), start, c.model, 10, 4)
+			var ts time.Time
+			for i := 1; i <= 90; i++ {
+				ts = start.Add(time.Duration(i) * frame)
+				filter.Update(boxAt(c.path(ts.Sub(start).Seconds())), ts)
+			}
+			expected := boxAt(c.path(ts.Sub(start).Seconds()))
+			if box := filter.Box(); !near(box.Min, expected.Min, 2) || !near(box.Max, expected.Max, 2) {
+				t.Fatalf("Expected box %v, got %v", expected, box)
+			}
+			if speed := filter.Speed(); !near(speed, c.speed, 5) {
+				t.Fatalf("Expected speed %v, got %v", c.speed, speed)
+			}
 
-	http.Handle("/", output_stream)
+			// half a second without detections
+			later := ts.Add(15 * frame)
+			expected = boxAt(c.path(later.Sub(start).Seconds()))
+			if box := filter.PredictBox(later); !near(box.Min, expected.Min, 5) || !near(box.Max, expected.Max, 5) {
+				t.Fatalf("Expected predicted box %v, got %v", expected, box)
+			}
+		})
+	}
+}
 
-	server := &http.Server{
-		Addr:         "0.0.0.0:8080",
-		ReadTimeout:  0,
-		WriteTimeout: 0,
+func TestCovariance(t *testing.T) {
+	cases := []struct {
+		name  string
+		model Model
+		dim   int
+	}{
+		{"velocity", ModelConstantVelocity, 8},
+		{"acceleration", ModelConstantAcceleration, 12},
 	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start := time.Unix(0, 0)
+			box := image.Rect(100, 100, 150, 220)
+			filter := NewFilter(box, start, c.model, 10, 4)
+			if dim := filter.Covariance().SymmetricDim(); dim != c.dim {
+				t.Fatalf("Expected a %dx%d covariance, got %dx%d", c.dim, c.dim, dim, dim)
+			}
+			initial := filter.Covariance().At(meas_dim, meas_dim)
 
-	err_chan := make(chan error)
+			ts := start
+			for i := 1; i <= 30; i++ {
+				ts = start.Add(time.Duration(i) * frame)
+				filter.Update(box, ts)
+			}
+			updated := filter.Covariance()
+			if updated.At(meas_dim, meas_dim) >= initial {
+				t.Fatalf("Speed variance didn't shrink with measurements: %f -> %f", initial, updated.At(meas_dim, meas_dim))
+			}
+			for i := range c.dim {
+				if updated.At(i, i) <= 0 {
+					t.Fatalf("Non-positive variance %f at %d", updated.At(i, i), i)
+				}
+			}
 
-	go func() {
-		err_chan <- server.ListenAndServe()
-	}()
+			// predicting without measurements only adds uncertainty
+			position := updated.At(0, 0)
+			filter.Predict(ts.Add(time.Second))
+			if predicted := filter.Covariance().At(0, 0); predicted <= position {
+				t.Fatalf("Position variance didn't grow without measurements: %f -> %f", position, predicted)
+			}
 
-	img := gocv.NewMatWithSize(H, W, gocv.MatTypeCV8UC3)
-	defer img.Close()
+			// the returned covariance is a copy
+			cov := filter.Covariance()
+			cov.SetSym(0, 0, -1)
+			if filter.Covariance().At(0, 0) < 0 {
+				t.Fatalf("Covariance shares memory with the filter")
+			}
+		})
+	}
+}
 
-	set := false
-	var kf *Filter
-	var avg, meas, noisy, prev image.Point
-	sma := gsma.NewSMA2d(4)
-	for i := range 5000 {
-		<-time.NewTimer(time.Millisecond * time.Duration(80*(0.75+rand.Float32()/2))).C
-		tm := time.Now().Sub(t0)
-		meas = image.Pt(
-			int(normSin(1.0/17.0, tm.Seconds())*W),
-			int(normCos(1.0/5.0, tm.Seconds())*H),
-		)
-		noisy = meas.Add(image.Pt(int(rand.NormFloat64()*30), int(rand.NormFloat64()*30)))
-		if !set {
-			kf = NewFilter(noisy, time.Now(), 0.001, 900)
-		}
-		if i < 200 || 210 < i {
-			kf.Update(noisy, time.Now())
-		} else {
-			kf.Predict(time.Now())
-		}
-		// t.Logf("Meas: %v, Filtered: %v", noisy, kf.State())
-		// t.Logf("Noise: %v", kf.Noise())
-		// t.Logf("Process: %v", kf.Process())
-		img.SubtractUChar(0b100001)
-		gocv.Circle(&img, noisy, 2, color.RGBA{255, 0, 0, 255}, 3)
-		avg = sma.Recalc(kf.State())
-		t.Logf("Avg: %v", avg)
-		if set {
-			gocv.Line(&img, prev, avg, color.RGBA{0, 0, 255, 255}, 2)
+func TestMahalanobis(t *testing.T) {
+	start := time.Unix(0, 0)
+	box := image.Rect(100, 100, 150, 220)
+	filter := NewFilter(box, start, ModelConstantVelocity, 10, 4)
+	ts := start
+	for i := 1; i <= 30; i++ {
+		ts = start.Add(time.Duration(i) * frame)
+		filter.Update(box, ts)
+	}
+	next := ts.Add(frame)
+	box = filter.PredictBox(next)
+	predicted := box.Min.Add(box.Max).Div(2)
+	if !near(predicted, image.Pt(125, 160), 1) {
+		t.Fatalf("Expected the center to stay at (125, 160), got %v", predicted)
+	}
+
+	cases := []struct {
+		offset image.Point
+		// squared distance
+		min, max float64
+	}{
+		{image.Pt(0, 0), 0, 0.1},
+		// a standard deviation is a bit over 2px
+		{image.Pt(2, 0), 0.5, 1},
+		{image.Pt(0, -2), 0.5, 1},
+		{image.Pt(20, 20), 50, math.Inf(1)},
+	}
+	for _, c := range cases {
+		if d2 := filter.SquaredMahalanobis(predicted.Add(c.offset), next); d2 < c.min || d2 > c.max {
+			t.Fatalf("Offset %v: expected squared distance in [%f, %f], got %f", c.offset, c.min, c.max, d2)
 		}
-		prev = avg
-		set = true
-		// gocv.Line(&img, kf.State(), kf.State().Add(kf.Speed().Mul(10)), color.RGBA{0, 255, 0, 255}, 1)
-		output_stream.UpdateJPEG(toJpeg(img))
 	}
-	shutdown_context, cancel := context.WithTimeout(
-		context.Background(),
-		time.Second*1)
-	defer cancel()
-	server.Shutdown(shutdown_context)
+
+	// farther in the future the same offset is more plausible
+	if near, far := filter.SquaredMahalanobis(predicted.Add(image.Pt(20, 0)), next),
+		filter.SquaredMahalanobis(predicted.Add(image.Pt(20, 0)), next.Add(2*time.Second)); far >= near {
+		t.Fatalf("Expected the distance to shrink with time, got %f then %f", near, far)
+	}
+}
+
+func near(a, b image.Point, tolerance int) bool {
+	d := a.Sub(b)
+	return max(d.X, -d.X) <= tolerance && max(d.Y, -d.Y) <= tolerance
 }
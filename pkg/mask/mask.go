@@ -0,0 +1,117 @@
+package mask
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/zones"
+)
+
+var (
+	ERR_MASK = errors.New("Bad mask")
+)
+
+// Samples per side of a box for the area anchor
+const area_samples = 16
+
+// Drops the detections that are masked instead of painting over the
+// frame. A point is masked if it's inside an exclusion polygon or
+// outside all the include polygons when there are any
+type Filter struct {
+	exclude []zones.Zone
+	include []zones.Zone
+	anchor  config.MaskAnchor
+	// share of the box that has to be masked, area anchor only
+	fraction float64
+}
+
+func polygons(contours []config.Contour, kind string) ([]zones.Zone, error) {
+	polygons := make([]zones.Zone, 0, len(contours))
+	for i, contour := range contours {
+		if len(contour) < 3 {
+			return nil, fmt.Errorf("%w: %s contour %d has %d points, need 3", ERR_MASK, kind, i, len(contour))
+		}
+		polygon := make([]image.Point, 0, len(contour))
+		for _, point := range contour {
+			polygon = append(polygon, image.Pt(int(point.X), int(point.Y)))
+		}
+		polygons = append(polygons, zones.Zone{Polygon: polygon})
+	}
+	return polygons, nil
+}
+
+// Filter of a filter mode mask, nil for a fill mode one
+func FromConfig(cfg config.MaskConfig) (*Filter, error) {
+	switch cfg.Mode {
+	case "", config.MaskModeFill:
+		return nil, nil
+	case config.MaskModeFilter:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %s", ERR_MASK, cfg.Mode)
+	}
+	exclude, err := polygons(cfg.Contours, "exclusion")
+	if err != nil {
+		return nil, err
+	}
+	include, err := polygons(cfg.Include, "include")
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{exclude: exclude, include: include, anchor: cfg.Anchor}
+	switch cfg.Anchor {
+	case "":
+		f.anchor = config.MaskAnchorFoot
+	case config.MaskAnchorFoot:
+	case config.MaskAnchorArea:
+		if cfg.Fraction <= 0 || cfg.Fraction > 1 {
+			return nil, fmt.Errorf("%w: fraction %f, expected more than 0 and up to 1", ERR_MASK, cfg.Fraction)
+		}
+		f.fraction = cfg.Fraction
+	default:
+		return nil, fmt.Errorf("%w: unknown anchor %s", ERR_MASK, cfg.Anchor)
+	}
+	return f, nil
+}
+
+func (f *Filter) masked(p image.Point) bool {
+	for _, polygon := range f.exclude {
+		if polygon.Contains(p) {
+			return true
+		}
+	}
+	for _, polygon := range f.include {
+		if polygon.Contains(p) {
+			return false
+		}
+	}
+	return len(f.include) > 0
+}
+
+// Share of the box that is masked, sampled on a grid
+func (f *Filter) maskedShare(box image.Rectangle) float64 {
+	cols, rows := min(box.Dx(), area_samples), min(box.Dy(), area_samples)
+	if cols <= 0 || rows <= 0 {
+		return 0
+	}
+	var masked int
+	for row := range rows {
+		y := box.Min.Y + (2*row+1)*box.Dy()/(2*rows)
+		for col := range cols {
+			x := box.Min.X + (2*col+1)*box.Dx()/(2*cols)
+			if f.masked(image.Pt(x, y)) {
+				masked++
+			}
+		}
+	}
+	return float64(masked) / float64(cols*rows)
+}
+
+// False if the detection with this box has to be dropped
+func (f *Filter) Keep(box image.Rectangle) bool {
+	if f.anchor == config.MaskAnchorArea {
+		return f.maskedShare(box) < f.fraction
+	}
+	return !f.masked(image.Pt((box.Min.X+box.Max.X)/2, box.Max.Y))
+}
@@ -0,0 +1,76 @@
+package mask
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+// left half of a 200x100 frame
+var left = config.Contour{{X: 0, Y: 0}, {X: 100, Y: 0}, {X: 100, Y: 100}, {X: 0, Y: 100}}
+
+func TestKeep(t *testing.T) {
+	cases := []struct {
+		name     string
+		cfg      config.MaskConfig
+		box      image.Rectangle
+		expected bool
+	}{
+		{"foot excluded", config.MaskConfig{Mode: config.MaskModeFilter, Contours: []config.Contour{left}},
+			image.Rect(40, 10, 60, 90), false},
+		{"foot outside the exclusion", config.MaskConfig{Mode: config.MaskModeFilter, Contours: []config.Contour{left}},
+			image.Rect(140, 10, 160, 90), true},
+		// partly masked but the feet aren't
+		{"foot straddling", config.MaskConfig{Mode: config.MaskModeFilter, Contours: []config.Contour{left}},
+			image.Rect(80, 10, 200, 90), true},
+		{"foot included", config.MaskConfig{Mode: config.MaskModeFilter, Include: []config.Contour{left}},
+			image.Rect(40, 10, 60, 90), true},
+		{"foot not included", config.MaskConfig{Mode: config.MaskModeFilter, Include: []config.Contour{left}},
+			image.Rect(140, 10, 160, 90), false},
+		{"area below the fraction", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: config.MaskAnchorArea, Fraction: 0.5,
+			Contours: []config.Contour{left}}, image.Rect(80, 10, 180, 90), true},
+		{"area above the fraction", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: config.MaskAnchorArea, Fraction: 0.5,
+			Contours: []config.Contour{left}}, image.Rect(20, 10, 120, 90), false},
+		{"area mostly not included", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: config.MaskAnchorArea, Fraction: 0.5,
+			Include: []config.Contour{left}}, image.Rect(80, 10, 180, 90), false},
+		{"tiny box", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: config.MaskAnchorArea, Fraction: 0.5,
+			Contours: []config.Contour{left}}, image.Rect(150, 50, 151, 51), true},
+	}
+	for _, c := range cases {
+		f, err := FromConfig(c.cfg)
+		if err != nil {
+			t.Fatalf("%s: can't create filter: %s", c.name, err)
+		}
+		if keep := f.Keep(c.box); keep != c.expected {
+			t.Fatalf("%s: expected %t for %v", c.name, c.expected, c.box)
+		}
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  config.MaskConfig
+		err  error
+		fill bool
+	}{
+		{"legacy", config.MaskConfig{Contours: []config.Contour{left}}, nil, true},
+		{"fill", config.MaskConfig{Mode: config.MaskModeFill}, nil, true},
+		{"filter", config.MaskConfig{Mode: config.MaskModeFilter}, nil, false},
+		{"unknown mode", config.MaskConfig{Mode: "blur"}, ERR_MASK, false},
+		{"unknown anchor", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: "head"}, ERR_MASK, false},
+		{"no fraction", config.MaskConfig{Mode: config.MaskModeFilter, Anchor: config.MaskAnchorArea}, ERR_MASK, false},
+		{"too few points", config.MaskConfig{Mode: config.MaskModeFilter, Include: []config.Contour{left[:2]}}, ERR_MASK, false},
+	}
+	for _, c := range cases {
+		f, err := FromConfig(c.cfg)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && (f == nil) != c.fill {
+			t.Fatalf("%s: expected a filter %t, got %v", c.name, !c.fill, f)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package onnx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ERR_NO_METADATA = errors.New("No such metadata")
+	ERR_METADATA    = errors.New("Malformed metadata")
+)
+
+// Field numbers from onnx.proto
+const (
+	modelGraph         = 7
+	modelMetadataProps = 14
+
+	entryKey   = 1
+	entryValue = 2
+
+	graphInitializer = 5
+	graphInput       = 11
+	graphOutput      = 12
+
+	tensorName = 8
+
+	valueInfoName = 1
+	valueInfoType = 2
+
+	typeTensorType = 1
+	tensorShape    = 2
+	shapeDim       = 1
+	dimValue       = 1
+)
+
+// Graph input or output. Dynamic dimensions are -1
+type Tensor struct {
+	Name  string
+	Shape []int64
+}
+
+type Model struct {
+	Metadata map[string]string
+	Inputs   []Tensor
+	Outputs  []Tensor
+}
+
+func Read(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %w", path, err)
+	}
+	model, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s: %w", path, err)
+	}
+	return model, nil
+}
+
+// Parses a serialized ModelProto skipping everything but
+// the metadata and the graph's inputs and outputs
+func Parse(data []byte) (*Model, error) {
+	model := &Model{Metadata: make(map[string]string)}
+	var graph []byte
+	err := walk(data, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch field {
+		case modelGraph:
+			b, err := r.bytes()
+			graph = b
+			return true, err
+		case modelMetadataProps:
+			b, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			key, value, err := parseEntry(b)
+			model.Metadata[key] = value
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if graph == nil {
+		return model, nil
+	}
+	initializers := make(map[string]bool)
+	var inputs []Tensor
+	err = walk(graph, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch field {
+		case graphInitializer:
+			b, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			name, err := parseInitializerName(b)
+			initializers[name] = true
+			return true, err
+		case graphInput, graphOutput:
+			b, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			tensor, err := parseValueInfo(b)
+			if field == graphInput {
+				inputs = append(inputs, tensor)
+			} else {
+				model.Outputs = append(model.Outputs, tensor)
+			}
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// older models list their weights as graph inputs too
+	for _, input := range inputs {
+		if !initializers[input.Name] {
+			model.Inputs = append(model.Inputs, input)
+		}
+	}
+	return model, nil
+}
+
+func parseEntry(b []byte) (string, string, error) {
+	var key, value string
+	err := walk(b, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes || (field != entryKey && field != entryValue) {
+			return false, nil
+		}
+		s, err := r.bytes()
+		if field == entryKey {
+			key = string(s)
+		} else {
+			value = string(s)
+		}
+		return true, err
+	})
+	return key, value, err
+}
+
+func parseInitializerName(b []byte) (string, error) {
+	var name string
+	err := walk(b, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes || field != tensorName {
+			return false, nil
+		}
+		s, err := r.bytes()
+		name = string(s)
+		return true, err
+	})
+	return name, err
+}
+
+// Descends into a message field with the given number
+func nested(b []byte, number int, f func(b []byte) error) error {
+	return walk(b, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes || field != number {
+			return false, nil
+		}
+		s, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		return true, f(s)
+	})
+}
+
+func parseValueInfo(b []byte) (Tensor, error) {
+	var tensor Tensor
+	err := walk(b, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch field {
+		case valueInfoName:
+			s, err := r.bytes()
+			tensor.Name = string(s)
+			return true, err
+		case valueInfoType:
+			s, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			// ValueInfoProto.type.tensor_type.shape.dim
+			return true, nested(s, typeTensorType, func(b []byte) error {
+				return nested(b, tensorShape, func(b []byte) error {
+					tensor.Shape = make([]int64, 0)
+					return nested(b, shapeDim, func(b []byte) error {
+						dim, err := parseDim(b)
+						tensor.Shape = append(tensor.Shape, dim)
+						return err
+					})
+				})
+			})
+		}
+		return false, nil
+	})
+	return tensor, err
+}
+
+func parseDim(b []byte) (int64, error) {
+	var dim int64 = -1
+	err := walk(b, func(field, wire int, r *reader) (bool, error) {
+		if wire != wireVarint || field != dimValue {
+			return false, nil
+		}
+		v, err := r.varint()
+		dim = int64(v)
+		return true, err
+	})
+	// symbolic dimensions (dim_param) are dynamic
+	if dim <= 0 {
+		dim = -1
+	}
+	return dim, err
+}
+
+// Class names from the "names" metadata written by ultralytics,
+// a python dict literal like {0: 'person', 1: 'bicycle'}.
+// Missing indices are filled with the index itself
+func (m *Model) Names() ([]string, error) {
+	raw, ok := m.Metadata["names"]
+	if !ok {
+		return nil, fmt.Errorf("%w: names", ERR_NO_METADATA)
+	}
+	dict, err := parseDict(raw)
+	if err != nil {
+		return nil, err
+	}
+	size := 0
+	for class_id := range dict {
+		size = max(size, class_id+1)
+	}
+	names := make([]string, size)
+	for class_id := range names {
+		if name, ok := dict[class_id]; ok {
+			names[class_id] = name
+		} else {
+			names[class_id] = strconv.Itoa(class_id)
+		}
+	}
+	return names, nil
+}
+
+// Max stride of the model from the "stride" metadata
+func (m *Model) Stride() (int, error) {
+	raw, ok := m.Metadata["stride"]
+	if !ok {
+		return 0, fmt.Errorf("%w: stride", ERR_NO_METADATA)
+	}
+	ints, err := parseInts(raw)
+	if err != nil || len(ints) == 0 {
+		return 0, fmt.Errorf("%w: stride %q", ERR_METADATA, raw)
+	}
+	return ints[len(ints)-1], nil
+}
+
+// "detect", "segment", "pose" etc. Empty if unknown
+func (m *Model) Task() string {
+	return m.Metadata["task"]
+}
+
+// Input image width and height. Taken from the first input's
+// NCHW shape or the "imgsz" metadata if the shape is dynamic
+func (m *Model) InputSize() (int, int, error) {
+	if len(m.Inputs) > 0 {
+		shape := m.Inputs[0].Shape
+		if len(shape) == 4 && shape[2] > 0 && shape[3] > 0 {
+			return int(shape[3]), int(shape[2]), nil
+		}
+	}
+	raw, ok := m.Metadata["imgsz"]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: imgsz", ERR_NO_METADATA)
+	}
+	ints, err := parseInts(raw)
+	if err != nil || len(ints) != 2 {
+		return 0, 0, fmt.Errorf("%w: imgsz %q", ERR_METADATA, raw)
+	}
+	// imgsz is [h, w]
+	return ints[1], ints[0], nil
+}
+
+// Parses "32", "[8, 16, 32]" or "(640, 640)"
+func parseInts(raw string) ([]int, error) {
+	raw = strings.Trim(strings.TrimSpace(raw), "[]()")
+	var ints []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, v)
+	}
+	return ints, nil
+}
+
+// Parses a python dict literal with int keys and quoted string values
+func parseDict(raw string) (map[int]string, error) {
+	bad := func(what string) error {
+		return fmt.Errorf("%w: %s in %q", ERR_METADATA, what, raw)
+	}
+	s := strings.TrimSpace(raw)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, bad("not a dict")
+	}
+	s = s[1 : len(s)-1]
+	dict := make(map[int]string)
+	for {
+		s = strings.TrimLeft(s, " \t\n,")
+		if s == "" {
+			return dict, nil
+		}
+		colon := strings.IndexByte(s, ':')
+		if colon < 0 {
+			return nil, bad("missing colon")
+		}
+		key, err := strconv.Atoi(strings.TrimSpace(s[:colon]))
+		if err != nil {
+			return nil, bad("non integer key")
+		}
+		s = strings.TrimLeft(s[colon+1:], " \t\n")
+		if s == "" || (s[0] != '\'' && s[0] != '"') {
+			return nil, bad("unquoted value")
+		}
+		quote := s[0]
+		var value strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != quote; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, bad("unterminated string")
+		}
+		dict[key] = value.String()
+		s = s[i+1:]
+	}
+}
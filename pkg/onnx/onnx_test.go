@@ -0,0 +1,178 @@
+package onnx
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tiny protobuf encoder to build synthetic models
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func msg(field int, payload []byte) []byte {
+	b := appendVarint(nil, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+func str(field int, s string) []byte {
+	return msg(field, []byte(s))
+}
+
+func varint(field int, v uint64) []byte {
+	return appendVarint(appendVarint(nil, uint64(field)<<3|wireVarint), v)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+// dims of 0 are encoded as symbolic
+func valueInfo(name string, dims ...int64) []byte {
+	var shape []byte
+	for _, dim := range dims {
+		if dim > 0 {
+			shape = append(shape, msg(shapeDim, varint(dimValue, uint64(dim)))...)
+		} else {
+			shape = append(shape, msg(shapeDim, str(2, "batch"))...)
+		}
+	}
+	tensor_type := concat(varint(1, 1), msg(tensorShape, shape))
+	return concat(str(valueInfoName, name), msg(valueInfoType, msg(typeTensorType, tensor_type)))
+}
+
+func entry(key, value string) []byte {
+	return concat(str(entryKey, key), str(entryValue, value))
+}
+
+func yolov8() []byte {
+	graph := concat(
+		str(1, "node"),
+		msg(graphInitializer, concat(varint(1, 3), str(tensorName, "weights"), msg(9, []byte{1, 2, 3, 4}))),
+		msg(graphInput, valueInfo("images", 1, 3, 640, 480)),
+		msg(graphInput, valueInfo("weights", 16)),
+		msg(graphOutput, valueInfo("output0", 1, 84, 6300)),
+	)
+	return concat(
+		varint(1, 8),
+		str(2, "pytorch"),
+		msg(modelGraph, graph),
+		msg(modelMetadataProps, entry("stride", "32")),
+		msg(modelMetadataProps, entry("task", "detect")),
+		msg(modelMetadataProps, entry("names", "{0: 'person', 1: 'bicycle', 3: \"motor, cycle\", 4: 'rider\\'s'}")),
+		msg(modelMetadataProps, entry("imgsz", "[480, 640]")),
+	)
+}
+
+func TestParse(t *testing.T) {
+	model, err := Parse(yolov8())
+	if err != nil {
+		t.Fatalf("Can't parse: %s", err)
+	}
+	if len(model.Inputs) != 1 || model.Inputs[0].Name != "images" {
+		t.Fatalf("Expected the weights to be filtered out of the inputs, got %v", model.Inputs)
+	}
+	if len(model.Outputs) != 1 || model.Outputs[0].Name != "output0" ||
+		len(model.Outputs[0].Shape) != 3 || model.Outputs[0].Shape[1] != 84 || model.Outputs[0].Shape[2] != 6300 {
+		t.Fatalf("Unexpected outputs: %v", model.Outputs)
+	}
+	if model.Task() != "detect" {
+		t.Fatalf("Unexpected task %q", model.Task())
+	}
+	stride, err := model.Stride()
+	if err != nil || stride != 32 {
+		t.Fatalf("Unexpected stride %d (%v)", stride, err)
+	}
+	w, h, err := model.InputSize()
+	if err != nil || w != 480 || h != 640 {
+		t.Fatalf("Expected 480x640 from the input shape, got %dx%d (%v)", w, h, err)
+	}
+	names, err := model.Names()
+	if err != nil {
+		t.Fatalf("Can't read names: %s", err)
+	}
+	expected := []string{"person", "bicycle", "2", "motor, cycle", "rider's"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected names %q, got %q", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected names %q, got %q", expected, names)
+		}
+	}
+}
+
+func TestDynamicInput(t *testing.T) {
+	data := concat(
+		msg(modelGraph, msg(graphInput, valueInfo("images", 0, 3, 0, 0))),
+		msg(modelMetadataProps, entry("imgsz", "[480, 640]")),
+		msg(modelMetadataProps, entry("stride", "[8, 16, 32]")),
+	)
+	model, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Can't parse: %s", err)
+	}
+	if shape := model.Inputs[0].Shape; len(shape) != 4 || shape[0] != -1 || shape[2] != -1 {
+		t.Fatalf("Expected dynamic dimensions, got %v", shape)
+	}
+	w, h, err := model.InputSize()
+	if err != nil || w != 640 || h != 480 {
+		t.Fatalf("Expected 640x480 from imgsz, got %dx%d (%v)", w, h, err)
+	}
+	if stride, err := model.Stride(); err != nil || stride != 32 {
+		t.Fatalf("Unexpected stride %d (%v)", stride, err)
+	}
+}
+
+func TestNoMetadata(t *testing.T) {
+	model, err := Parse(msg(modelGraph, msg(graphOutput, valueInfo("detection_out", 1, 1, 100, 7))))
+	if err != nil {
+		t.Fatalf("Can't parse: %s", err)
+	}
+	if _, err := model.Names(); !errors.Is(err, ERR_NO_METADATA) {
+		t.Fatalf("Expected missing names, got %v", err)
+	}
+	if _, _, err := model.InputSize(); !errors.Is(err, ERR_NO_METADATA) {
+		t.Fatalf("Expected unknown input size, got %v", err)
+	}
+	model.Metadata["names"] = "['person', 'car']"
+	if _, err := model.Names(); !errors.Is(err, ERR_METADATA) {
+		t.Fatalf("Expected malformed names, got %v", err)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := yolov8()
+	if _, err := Parse(data[:len(data)-3]); !errors.Is(err, ERR_TRUNCATED) {
+		t.Fatalf("Expected a truncation error, got %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.onnx")
+	if err := os.WriteFile(path, yolov8(), 0o644); err != nil {
+		t.Fatalf("Can't write model: %s", err)
+	}
+	model, err := Read(path)
+	if err != nil {
+		t.Fatalf("Can't read model: %s", err)
+	}
+	if model.Metadata["task"] != "detect" {
+		t.Fatalf("Unexpected metadata %v", model.Metadata)
+	}
+	if _, err := Read(path + ".missing"); err == nil {
+		t.Fatalf("Expected an error for a missing file")
+	}
+}
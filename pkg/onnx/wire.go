@@ -0,0 +1,110 @@
+package onnx
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ERR_TRUNCATED = errors.New("Truncated message")
+	ERR_WIRE_TYPE = errors.New("Unsupported wire type")
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Minimal reader of the protobuf wire format,
+// just enough to walk an ONNX model
+type reader struct {
+	b []byte
+}
+
+func (r *reader) done() bool { return len(r.b) == 0 }
+
+func (r *reader) varint() (uint64, error) {
+	var v uint64
+	for i := 0; i < 10; i++ {
+		if i >= len(r.b) {
+			return 0, ERR_TRUNCATED
+		}
+		c := r.b[i]
+		v |= uint64(c&0x7f) << (7 * i)
+		if c < 0x80 {
+			r.b = r.b[i+1:]
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: varint overflow", ERR_TRUNCATED)
+}
+
+// Reads the next field key
+func (r *reader) key() (int, int, error) {
+	k, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(k >> 3), int(k & 7), nil
+}
+
+func (r *reader) bytes() ([]byte, error) {
+	l, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(r.b)) {
+		return nil, ERR_TRUNCATED
+	}
+	v := r.b[:l]
+	r.b = r.b[l:]
+	return v, nil
+}
+
+func (r *reader) skip(wire int) error {
+	var n int
+	switch wire {
+	case wireVarint:
+		_, err := r.varint()
+		return err
+	case wireBytes:
+		_, err := r.bytes()
+		return err
+	case wireFixed64:
+		n = 8
+	case wireFixed32:
+		n = 4
+	default:
+		return fmt.Errorf("%w: %d", ERR_WIRE_TYPE, wire)
+	}
+	if n > len(r.b) {
+		return ERR_TRUNCATED
+	}
+	r.b = r.b[n:]
+	return nil
+}
+
+// Calls f for every field of message b. f has to consume the value
+// of the fields it handles and return false for the ones to skip
+func walk(b []byte, f func(field, wire int, r *reader) (bool, error)) error {
+	r := &reader{b}
+	for !r.done() {
+		field, wire, err := r.key()
+		if err != nil {
+			return err
+		}
+		handled, err := f(field, wire, r)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			if err := r.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -4,56 +4,129 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"math"
 	"time"
 
 	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/ghung"
 	"github.com/Robogera/detect/pkg/gmat"
-	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
+	"github.com/Robogera/detect/pkg/homography"
+	"github.com/Robogera/detect/pkg/kalman"
 	"github.com/Robogera/detect/pkg/seq"
-	hung "github.com/arthurkushman/go-hungarian"
+	"github.com/Robogera/detect/pkg/yolo"
 	"gocv.io/x/gocv"
 )
 
 type Detection struct {
-	Box        image.Rectangle
+	yolo.Detection
 	Descriptor []float32
 	Associated bool
 }
 
+type scoreWeights struct {
+	appearance float64
+	iou        float64
+	motion     float64
+}
+
+func (w scoreWeights) total() float64 {
+	return w.appearance + w.iou + w.motion
+}
+
 type Associator struct {
-	p           map[string]*Person
-	net         *gocv.Net
-	conv_params *gocv.ImageToBlobParams
+	p        map[string]*Person
+	embedder Embedder
+	metric   *Metric
+	weights  scoreWeights
+	// 0 for no gating
+	gate float64
+	// whether detections below the confidence threshold are
+	// passed from the detector
+	low_tier     bool
+	motion_model kalman.Model
 
 	validation_duration          time.Duration
 	prediction_duration          time.Duration
 	expiration_duration          time.Duration
 	nonvalid_expiration_duration time.Duration
+	oob_expiration_duration      time.Duration
+	// inset of the frame bounds
+	bounds_margin int
+	// since the last call to Events
+	events []Event
+	// nil for no floor positions
+	homography *homography.Homography
 
-	cfg *config.ConfigFile
+	cfg     *config.ConfigFile
+	classes yolo.Classes
 	// visual stuff
 	trajectory_points uint
 	next_color        color.Color
 }
 
-func NewAssociator(net *gocv.Net, conv_params *gocv.ImageToBlobParams, cfg *config.ConfigFile) (*Associator, error) {
-	if !gocvcommon.CheckLayerName(net, cfg.Reid.OutputLayerName) {
-		return nil, fmt.Errorf("Model has no layer %s", cfg.Reid.OutputLayerName)
+// A nil embedder makes the associator track by motion and box
+// overlap only
+func NewAssociator(embedder Embedder, cfg *config.ConfigFile, classes yolo.Classes) (*Associator, error) {
+	metric, err := NewMetric(cfg.Reid.Metric, cfg.Reid.Aggregation)
+	if err != nil {
+		return nil, err
+	}
+	weights := scoreWeights{
+		appearance: cfg.Reid.AppearanceWeight,
+		iou:        cfg.Reid.IoUWeight,
+		motion:     cfg.Reid.MotionWeight,
+	}
+	if weights.appearance < 0 || weights.iou < 0 || weights.motion < 0 {
+		return nil, fmt.Errorf("Negative association weights: %+v", weights)
+	}
+	if embedder == nil {
+		weights.appearance = 0
+		if weights.total() == 0 {
+			weights.iou = 1
+		}
+	}
+	if weights.total() == 0 {
+		// appearance only
+		weights.appearance = 1
+	}
+	var motion_model kalman.Model
+	switch cfg.Kalman.Model {
+	case config.MotionModelTypeVelocity, "":
+		motion_model = kalman.ModelConstantVelocity
+	case config.MotionModelTypeAcceleration:
+		motion_model = kalman.ModelConstantAcceleration
+	default:
+		return nil, fmt.Errorf("Unknown motion model: %s", cfg.Kalman.Model)
+	}
+	if cfg.Reid.Gate < 0 {
+		return nil, fmt.Errorf("Negative gate: %f", cfg.Reid.Gate)
 	}
 	return &Associator{
 		p:                            make(map[string]*Person, 0),
-		net:                          net,
-		conv_params:                  conv_params,
-		validation_duration:          time.Duration(cfg.Reid.ValidateSec) * time.Second,
-		expiration_duration:          time.Duration(cfg.Reid.ExpireSec) * time.Second,
-		nonvalid_expiration_duration: time.Duration(cfg.Reid.NonValidExpireSec) * time.Second,
-		prediction_duration:          time.Duration(cfg.Reid.PredictSec) * time.Second,
+		embedder:                     embedder,
+		metric:                       metric,
+		weights:                      weights,
+		gate:                         cfg.Reid.Gate,
+		low_tier:                     cfg.Yolo.LowConfidenceThreshold > 0,
+		motion_model:                 motion_model,
+		validation_duration:          time.Duration(cfg.Reid.ValidateSec * float64(time.Second)),
+		expiration_duration:          time.Duration(cfg.Reid.ExpireSec * float64(time.Second)),
+		nonvalid_expiration_duration: time.Duration(cfg.Reid.NonValidExpireSec * float64(time.Second)),
+		oob_expiration_duration:      time.Duration(cfg.Reid.OOBExpireSec * float64(time.Second)),
+		bounds_margin:                int(cfg.Reid.BoundsMargin),
+		prediction_duration:          time.Duration(cfg.Reid.PredictSec * float64(time.Second)),
 		cfg:                          cfg,
+		classes:                      classes,
 		trajectory_points:            25,
 		next_color:                   color.RGBA{255, 0, 0, 255},
 	}, nil
 }
 
+// Makes the people tracked from now on export floor positions
+func (a *Associator) Calibrate(h *homography.Homography) {
+	a.homography = h
+}
+
 func (a *Associator) EnumeratePeople() []*Person {
 	people := make([]*Person, 0, len(a.p))
 	for _, person := range a.p {
@@ -63,104 +136,211 @@ func (a *Associator) EnumeratePeople() []*Person {
 }
 
 func (a *Associator) del(id string) {
-	a.p[id].filter.Free()
 	delete(a.p, id)
 }
 
 func (a *Associator) Associate(
 	m *gocv.Mat,
-	boxes []image.Rectangle,
+	found []yolo.Detection,
 	t time.Time,
-) {
+) error {
 
 	size := m.Size()
 	frame := image.Rect(0, 0, size[1], size[0])
-	detections := make([]*Detection, 0, len(boxes))
+	// high confidence detections continue and start tracks, low
+	// confidence ones only continue tracks the high ones didn't
+	var high, low []*Detection
+	var high_boxes []image.Rectangle
+	for _, detection := range found {
+		box := detection.Box
+		if !box.In(frame) {
+			box = box.Intersect(frame)
+		}
+		// TODO: use a fraction of the frame's height or something
+		// like that for a better threshold
+		if box.Dx() < 1 || box.Dy() < 1 {
+			continue
+		}
+		detection.Box = box
+		if a.low_tier && detection.Score < a.cfg.Yolo.ConfidenceThreshold {
+			low = append(low, &Detection{Detection: detection})
+			continue
+		}
+		high = append(high, &Detection{Detection: detection})
+		high_boxes = append(high_boxes, box)
+	}
 
-	for _, box := range boxes {
-		func() {
-			if !box.In(frame) {
-				box = box.Intersect(frame)
-			}
-			// TODO: use a fraction of the frame's height or something
-			// like that for a better threshold
-			if box.Dx() < 1 || box.Dy() < 1 {
-				return
-			}
-			region := m.Region(box)
-			defer region.Close()
-			fmt.Printf("Region:%v\n", region.Size())
-			blob := gocv.BlobFromImageWithParams(region, *a.conv_params)
-			defer blob.Close()
-			a.net.SetInput(blob, "")
-			output := a.net.Forward(a.cfg.Reid.OutputLayerName)
-			defer output.Close()
-			ptr, err := output.DataPtrFloat32()
-			if err != nil {
-				return
-			}
-			raw_data := make([]float32, len(ptr))
-			copy(raw_data, ptr)
-			detections = append(detections, &Detection{
-				Box:        box,
-				Descriptor: raw_data,
-				Associated: false,
-			})
-		}()
+	if a.embedder != nil && len(high) > 0 {
+		descriptors, err := a.embedder.Embed(m, high_boxes)
+		if err != nil {
+			return fmt.Errorf("Can't embed detections: %w", err)
+		}
+		for i, detection := range high {
+			detection.Descriptor = seq.Normalize(descriptors[i])
+		}
 	}
 
-	matrix_size := max(len(a.p), len(detections))
-	diss_mat := gmat.NewMat[float64](matrix_size, matrix_size)
+	// people expired by CleanUp are only kept to be exported once
+	enumerated := make([]*Person, 0, len(a.p))
+	for _, person := range a.p {
+		if person.Status() != STATUS_EXPIRED {
+			enumerated = append(enumerated, person)
+		}
+	}
+	predicted := make([]image.Rectangle, len(enumerated))
+	for pid, person := range enumerated {
+		predicted[pid] = person.predictedBox(t)
+	}
 
-	enumerated := a.EnumeratePeople()
+	matches := solve(len(enumerated), len(high), a.cfg.Reid.ScoreThreshold, func(pid, did int) (float64, bool) {
+		return a.score(enumerated[pid], predicted[pid], high[did], t)
+	})
 
+	var unmatched []int
 	for pid, person := range enumerated {
-		for did, detection := range detections {
-			scores := make([]float64, 0, person.descriptors.Size())
-			for descriptor := range person.descriptors.All() {
-				scores = append(scores, float64(seq.CosSim(descriptor, detection.Descriptor)))
-			}
-			score := seq.SqrtMean(scores)
-			// punishing distant pairs with lower score
-			if overshoot := person.distance(detection.Box) - float64(a.cfg.Reid.DistanceThreshold); overshoot > 0 {
-				score = score * (1 - max(1, overshoot/100.0*a.cfg.Reid.DistanceFactor))
-			}
-			diss_mat.Set(pid, did, score)
+		if did, ok := matches[pid]; ok {
+			high[did].Associated = true
+			a.continueTrack(person, t, high[did])
+		} else {
+			unmatched = append(unmatched, pid)
 		}
 	}
 
-	associations := hung.SolveMax(diss_mat.To2d())
+	// occluded people tend to get low scores, overlap with where they
+	// are expected is all there is to go by
+	low_matches := solve(len(unmatched), len(low), a.cfg.Reid.LowIoUThreshold, func(uid, did int) (float64, bool) {
+		pid := unmatched[uid]
+		return a.lowScore(enumerated[pid], predicted[pid], low[did], t)
+	})
 
-	for pid, person := range enumerated {
-		var did int
-		var score float64
-		// ugly syntax but kept for compatibility with the third party
-		// kahn algo packages
-		for did, score = range associations[pid] {
-			break
-		}
-		if did >= len(detections) || score < a.cfg.Reid.ScoreThreshold {
-			// the first condition means he got associated with a "dummy"
-			// detection column
-			person.predict(t, a.prediction_duration)
+	for uid, pid := range unmatched {
+		if did, ok := low_matches[uid]; ok {
+			low[did].Associated = true
+			a.continueTrack(enumerated[pid], t, low[did])
 		} else {
-			detections[did].Associated = true
-			person.update(t, detections[did].Box, detections[did].Descriptor)
+			a.loseTrack(enumerated[pid], t)
 		}
+	}
+	for _, person := range enumerated {
+		valid := person.IsValid()
 		person.validate(t, a.validation_duration, a.cfg.Reid.ValidationFrames)
+		if !valid && person.IsValid() {
+			a.emit(person, EVENT_VALIDATED, t)
+		}
 	}
-	for _, detection := range detections {
+	for _, detection := range high {
 		if !detection.Associated {
-			new_person, _ := a.NewPerson(t, detection.Box, detection.Descriptor)
+			new_person, _ := a.NewPerson(t, detection)
 			a.p[new_person.Id()] = new_person
+			a.emit(new_person, EVENT_CREATED, t)
 		}
 	}
+	return nil
 }
 
+func (a *Associator) continueTrack(person *Person, t time.Time, detection *Detection) {
+	status := person.Status()
+	person.update(t, detection)
+	if status == STATUS_LOST || status == STATUS_OOB {
+		a.emit(person, EVENT_RECOVERED, t)
+	}
+}
+
+func (a *Associator) loseTrack(person *Person, t time.Time) {
+	status := person.Status()
+	person.predict(t, a.prediction_duration)
+	if status != STATUS_LOST && person.Status() == STATUS_LOST {
+		a.emit(person, EVENT_LOST, t)
+	}
+}
+
+// Pairs rows with columns maximizing first the number of pairs and
+// then their total score. Returns the column of every matched row.
+// Pairs scoring below threshold or not ok are never matched
+func solve(rows, cols int, threshold float64, score func(row, col int) (float64, bool)) map[int]int {
+	matches := make(map[int]int)
+	if rows == 0 || cols == 0 {
+		return matches
+	}
+	cost_mat := gmat.NewMat[float64](rows, cols)
+	for row := range rows {
+		for col := range cols {
+			value, ok := score(row, col)
+			if !ok || value < threshold {
+				cost_mat.Set(row, col, math.Inf(1))
+				continue
+			}
+			cost_mat.Set(row, col, -value)
+		}
+	}
+	for row, col := range ghung.Solve(cost_mat) {
+		if col != ghung.Unassigned {
+			matches[row] = col
+		}
+	}
+	return matches
+}
+
+// Overlap of a low confidence detection with the predicted box. Not
+// ok without any, whatever the threshold
+func (a *Associator) lowScore(person *Person, predicted image.Rectangle, detection *Detection, t time.Time) (float64, bool) {
+	if detection.ClassId != person.class_id {
+		return 0, false
+	}
+	if a.gate > 0 && person.filter.SquaredMahalanobis(center(detection.Box), t) > a.gate {
+		return 0, false
+	}
+	overlap := iou(predicted, detection.Box)
+	return overlap, overlap > 0
+}
+
+// Weighted mean of the appearance similarity, overlap with the
+// predicted box and closeness to the predicted position. Not ok
+// for pairs that can never match
+func (a *Associator) score(person *Person, predicted image.Rectangle, detection *Detection, t time.Time) (float64, bool) {
+	// people don't turn into cars
+	if detection.ClassId != person.class_id {
+		return 0, false
+	}
+	var d2 float64
+	if a.gate > 0 || a.weights.motion > 0 {
+		d2 = person.filter.SquaredMahalanobis(center(detection.Box), t)
+		if a.gate > 0 && d2 > a.gate {
+			return 0, false
+		}
+	}
+	var score float64
+	if a.weights.appearance > 0 {
+		score += a.weights.appearance * a.metric.Score(person.descriptors.All(), detection.Descriptor)
+	}
+	if a.weights.iou > 0 {
+		score += a.weights.iou * iou(predicted, detection.Box)
+	}
+	if a.weights.motion > 0 {
+		score += a.weights.motion * math.Exp(-d2/2)
+	}
+	return score / a.weights.total(), true
+}
+
+// Deletes expired people and expires the ones not seen for too long.
+// People predicted beyond bounds shrunk by the margin go out of bounds
+// and expire sooner
 func (a *Associator) CleanUp(t time.Time, bounds image.Rectangle) {
+	inner := bounds.Inset(a.bounds_margin)
 	for _, person := range a.p {
 		if person.Status() == STATUS_EXPIRED {
 			a.del(person.id)
+			continue
+		}
+		status := person.Status()
+		person.checkBounds(t, inner)
+		if status != STATUS_OOB && person.Status() == STATUS_OOB {
+			a.emit(person, EVENT_OUT_OF_BOUNDS, t)
+		}
+		if person.Status() == STATUS_OOB {
+			if t.Sub(person.exit.Time) >= a.oob_expiration_duration {
+				person.last_status = STATUS_EXPIRED
+			}
 		} else {
 			since_update := person.SinceDetection(t)
 			if (!person.IsValid() && since_update > a.nonvalid_expiration_duration) ||
@@ -168,6 +348,9 @@ func (a *Associator) CleanUp(t time.Time, bounds image.Rectangle) {
 				person.last_status = STATUS_EXPIRED
 			}
 		}
+		if person.Status() == STATUS_EXPIRED {
+			a.emit(person, EVENT_EXPIRED, t)
+		}
 	}
 }
 
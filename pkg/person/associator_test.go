@@ -0,0 +1,761 @@
+package person
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/homography"
+	"github.com/Robogera/detect/pkg/yolo"
+	"gocv.io/x/gocv"
+)
+
+const (
+	test_w = 640
+	test_h = 480
+
+	test_frame_duration = 100 * time.Millisecond
+)
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+func testConfig() *config.ConfigFile {
+	cfg := &config.ConfigFile{}
+	cfg.Reid = config.ReidConfig{
+		SMAWindow:         3,
+		TotalDescriptors:  5,
+		PredictSec:        1,
+		ValidateSec:       0.2,
+		ExpireSec:         2,
+		NonValidExpireSec: 2,
+		ValidationFrames:  2,
+		ScoreThreshold:    0.5,
+		TokenLength:       4,
+	}
+	cfg.Kalman = config.KalmanConfig{
+		ProcessNoiseDensity: 0.01,
+		MeasNoiseCov:        1,
+	}
+	return cfg
+}
+
+// Person-sized box of an actor walking through the scene
+type actor struct {
+	color color.RGBA
+	box   image.Rectangle
+}
+
+// Draws the actors on a black frame in order and feeds their
+// boxes to the associator as detections
+func step(t *testing.T, a *Associator, now time.Time, actors ...actor) {
+	t.Helper()
+	m := gocv.NewMatWithSize(test_h, test_w, gocv.MatTypeCV8UC3)
+	defer m.Close()
+	found := make([]yolo.Detection, 0, len(actors))
+	for _, actor := range actors {
+		gocv.Rectangle(&m, actor.box, actor.color, -1)
+		found = append(found, yolo.Detection{Box: actor.box, Score: 0.9})
+	}
+	a.CleanUp(now, image.Rect(0, 0, test_w, test_h))
+	if err := a.Associate(&m, found, now); err != nil {
+		t.Fatalf("Can't associate: %s", err)
+	}
+}
+
+func newTestAssociator(t *testing.T, cfg *config.ConfigFile) *Associator {
+	t.Helper()
+	a, err := NewAssociator(colorEmbedder{}, cfg, yolo.Classes{0: "person"})
+	if err != nil {
+		t.Fatalf("Can't create associator: %s", err)
+	}
+	return a
+}
+
+// Id of the tracked person closest to p
+func closest(a *Associator, p image.Point) (string, Status) {
+	var id string
+	var status Status
+	best := -1.0
+	for _, person := range a.EnumeratePeople() {
+		if d := person.distance(image.Rectangle{p, p}); best < 0 || d < best {
+			best, id, status = d, person.Id(), person.Status()
+		}
+	}
+	return id, status
+}
+
+func TestColorEmbedder(t *testing.T) {
+	m := gocv.NewMatWithSize(test_h, test_w, gocv.MatTypeCV8UC3)
+	defer m.Close()
+	left, right := image.Rect(10, 10, 50, 90), image.Rect(100, 10, 140, 90)
+	gocv.Rectangle(&m, left, red, -1)
+	gocv.Rectangle(&m, right, blue, -1)
+	descriptors, err := colorEmbedder{}.Embed(&m, []image.Rectangle{left, right, left})
+	if err != nil {
+		t.Fatalf("Can't embed: %s", err)
+	}
+	if len(descriptors) != 3 {
+		t.Fatalf("Expected 3 descriptors, got %d", len(descriptors))
+	}
+	for i := range descriptors[0] {
+		if descriptors[0][i] != descriptors[2][i] {
+			t.Fatalf("Same box embedded differently: %v and %v", descriptors[0], descriptors[2])
+		}
+	}
+	var dot float32
+	for i := range descriptors[0] {
+		dot += descriptors[0][i] * descriptors[1][i]
+	}
+	if dot > 0.1 {
+		t.Fatalf("Red and blue boxes look alike: %v and %v", descriptors[0], descriptors[1])
+	}
+}
+
+func TestIdContinuity(t *testing.T) {
+	a := newTestAssociator(t, testConfig())
+	now := time.Unix(0, 0)
+	var id string
+	for i := range 30 {
+		box := image.Rect(20+i*10, 200, 60+i*10, 280)
+		step(t, a, now, actor{red, box})
+		if a.TotalPeople() != 1 {
+			t.Fatalf("Frame %d: expected 1 person, got %d", i, a.TotalPeople())
+		}
+		current := a.EnumeratePeople()[0]
+		if i == 0 {
+			id = current.Id()
+		} else if current.Id() != id {
+			t.Fatalf("Frame %d: id changed from %s to %s", i, id, current.Id())
+		}
+		now = now.Add(test_frame_duration)
+	}
+	if !a.EnumeratePeople()[0].IsValid() {
+		t.Fatalf("Person wasn't validated")
+	}
+}
+
+func TestCrossingPaths(t *testing.T) {
+	a := newTestAssociator(t, testConfig())
+	now := time.Unix(0, 0)
+	var red_id, blue_id string
+	for i := range 30 {
+		// walking towards each other a bit off the same line
+		red_box := image.Rect(100+i*15, 200, 140+i*15, 280)
+		blue_box := image.Rect(500-i*15, 215, 540-i*15, 295)
+		step(t, a, now, actor{red, red_box}, actor{blue, blue_box})
+		if a.TotalPeople() != 2 {
+			t.Fatalf("Frame %d: expected 2 people, got %d", i, a.TotalPeople())
+		}
+		if i == 0 {
+			red_id, _ = closest(a, center(red_box))
+			blue_id, _ = closest(a, center(blue_box))
+			if red_id == blue_id {
+				t.Fatalf("Both actors got the same id %s", red_id)
+			}
+		}
+		now = now.Add(test_frame_duration)
+	}
+	// the actors have swapped sides by now
+	if id, _ := closest(a, image.Pt(100+29*15+20, 240)); id != red_id {
+		t.Fatalf("Red actor switched ids: %s, expected %s", id, red_id)
+	}
+	if id, _ := closest(a, image.Pt(500-29*15+20, 255)); id != blue_id {
+		t.Fatalf("Blue actor switched ids: %s, expected %s", id, blue_id)
+	}
+}
+
+func TestOcclusion(t *testing.T) {
+	a := newTestAssociator(t, testConfig())
+	now := time.Unix(0, 0)
+	box := func(i int) image.Rectangle { return image.Rect(20+i*10, 200, 60+i*10, 280) }
+	var id string
+	for i := range 30 {
+		if i >= 10 && i < 15 {
+			// hidden behind something, nothing detected
+			step(t, a, now)
+			if _, status := closest(a, center(box(i))); status != STATUS_LOST {
+				t.Fatalf("Frame %d: expected the hidden person to be lost, got %s", i, status)
+			}
+		} else {
+			step(t, a, now, actor{red, box(i)})
+		}
+		if a.TotalPeople() != 1 {
+			t.Fatalf("Frame %d: expected 1 person, got %d", i, a.TotalPeople())
+		}
+		if i == 0 {
+			id = a.EnumeratePeople()[0].Id()
+		} else if current := a.EnumeratePeople()[0].Id(); current != id {
+			t.Fatalf("Frame %d: id changed from %s to %s", i, id, current)
+		}
+		now = now.Add(test_frame_duration)
+	}
+	if status := a.EnumeratePeople()[0].Status(); status != STATUS_ASSOCIATED {
+		t.Fatalf("Expected the person to be found again, got %s", status)
+	}
+}
+
+func TestIoU(t *testing.T) {
+	cases := []struct {
+		a, b     image.Rectangle
+		expected float64
+	}{
+		{image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10), 1},
+		{image.Rect(0, 0, 10, 10), image.Rect(5, 0, 15, 10), 50.0 / 150.0},
+		{image.Rect(0, 0, 10, 10), image.Rect(10, 10, 20, 20), 0},
+		{image.Rect(0, 0, 10, 10), image.Rectangle{}, 0},
+	}
+	for _, c := range cases {
+		if score := iou(c.a, c.b); !almostEqual(score, c.expected) {
+			t.Fatalf("iou(%v, %v): expected %f, got %f", c.a, c.b, c.expected, score)
+		}
+	}
+}
+
+// Two look-alikes walking in parallel can only be told apart by
+// where they are
+func TestIoUAssociation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.AppearanceWeight, cfg.Reid.IoUWeight = 0, 1
+	cfg.Reid.ScoreThreshold = 0.1
+	a := newTestAssociator(t, cfg)
+	now := time.Unix(0, 0)
+	var upper_id, lower_id string
+	for i := range 30 {
+		upper := image.Rect(20+i*10, 50, 60+i*10, 130)
+		lower := image.Rect(20+i*10, 150, 60+i*10, 230)
+		step(t, a, now, actor{red, upper}, actor{red, lower})
+		if a.TotalPeople() != 2 {
+			t.Fatalf("Frame %d: expected 2 people, got %d", i, a.TotalPeople())
+		}
+		upper_current, _ := closest(a, center(upper))
+		lower_current, _ := closest(a, center(lower))
+		if i == 0 {
+			upper_id, lower_id = upper_current, lower_current
+		} else if upper_current != upper_id || lower_current != lower_id {
+			t.Fatalf("Frame %d: ids changed from %s, %s to %s, %s",
+				i, upper_id, lower_id, upper_current, lower_current)
+		}
+		now = now.Add(test_frame_duration)
+	}
+}
+
+// A look-alike showing up far from where anyone is expected
+// is somebody else
+func TestGating(t *testing.T) {
+	for _, gate := range []float64{0, 9.21} {
+		cfg := testConfig()
+		cfg.Reid.Gate = gate
+		cfg.Kalman.MeasNoiseCov = 100
+		cfg.Kalman.ProcessNoiseDensity = 1
+		a := newTestAssociator(t, cfg)
+		now := time.Unix(0, 0)
+		for i := range 20 {
+			step(t, a, now, actor{red, image.Rect(20+i*10, 200, 60+i*10, 280)})
+			if a.TotalPeople() != 1 {
+				t.Fatalf("Gate %f, frame %d: expected normal walking to pass, got %d people", gate, i, a.TotalPeople())
+			}
+			now = now.Add(test_frame_duration)
+		}
+		step(t, a, now, actor{red, image.Rect(500, 20, 540, 100)})
+		expected := 2
+		if gate == 0 {
+			expected = 1
+		}
+		if a.TotalPeople() != expected {
+			t.Fatalf("Gate %f: expected %d people after the jump, got %d", gate, expected, a.TotalPeople())
+		}
+	}
+}
+
+func TestWithoutReid(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.ScoreThreshold = 0.1
+	a, err := NewAssociator(nil, cfg, yolo.Classes{0: "person"})
+	if err != nil {
+		t.Fatalf("Can't create associator: %s", err)
+	}
+	now := time.Unix(0, 0)
+	var id string
+	for i := range 20 {
+		step(t, a, now, actor{red, image.Rect(20+i*10, 200, 60+i*10, 280)})
+		if a.TotalPeople() != 1 {
+			t.Fatalf("Frame %d: expected 1 person, got %d", i, a.TotalPeople())
+		}
+		person := a.EnumeratePeople()[0]
+		if i == 0 {
+			id = person.Id()
+		} else if person.Id() != id {
+			t.Fatalf("Frame %d: id changed from %s to %s", i, id, person.Id())
+		}
+		if person.descriptors.Size() != 0 {
+			t.Fatalf("Frame %d: descriptors stored without reid", i)
+		}
+		now = now.Add(test_frame_duration)
+	}
+	if exported := a.EnumeratePeople()[0].Export(); exported.Id != id || exported.Class != "person" {
+		t.Fatalf("Unexpected export %+v", exported)
+	}
+}
+
+// Feeds the detections the way the detector would: dropping
+// everything below the lowest threshold in use
+func stepScored(t *testing.T, a *Associator, now time.Time, found ...yolo.Detection) {
+	t.Helper()
+	threshold := a.cfg.Yolo.ConfidenceThreshold
+	if low := a.cfg.Yolo.LowConfidenceThreshold; low > 0 {
+		threshold = low
+	}
+	m := gocv.NewMatWithSize(test_h, test_w, gocv.MatTypeCV8UC3)
+	defer m.Close()
+	passed := make([]yolo.Detection, 0, len(found))
+	for _, detection := range found {
+		gocv.Rectangle(&m, detection.Box, red, -1)
+		if detection.Score >= threshold {
+			passed = append(passed, detection)
+		}
+	}
+	a.CleanUp(now, image.Rect(0, 0, test_w, test_h))
+	if err := a.Associate(&m, passed, now); err != nil {
+		t.Fatalf("Can't associate: %s", err)
+	}
+}
+
+// Somebody walking behind a fence for a while gets low scores
+func TestLowConfidenceTier(t *testing.T) {
+	broken := make(map[float32]int)
+	for _, low_threshold := range []float32{0, 0.1} {
+		cfg := testConfig()
+		cfg.Yolo.ConfidenceThreshold = 0.5
+		cfg.Yolo.LowConfidenceThreshold = low_threshold
+		cfg.Reid.LowIoUThreshold = 0.3
+		cfg.Reid.ExpireSec, cfg.Reid.NonValidExpireSec = 0.3, 0.3
+		a := newTestAssociator(t, cfg)
+		now := time.Unix(0, 0)
+		ids := make(map[string]bool)
+		for i := range 30 {
+			score := float32(0.9)
+			if i >= 10 && i < 18 {
+				score = 0.3
+			}
+			stepScored(t, a, now, yolo.Detection{Box: image.Rect(20+i*10, 200, 60+i*10, 280), Score: score})
+			for _, person := range a.EnumeratePeople() {
+				if person.Status() != STATUS_EXPIRED {
+					ids[person.Id()] = true
+				}
+			}
+			now = now.Add(test_frame_duration)
+		}
+		broken[low_threshold] = len(ids) - 1
+	}
+	if broken[0.1] != 0 {
+		t.Fatalf("Expected the low tier to keep the track, got %d breaks", broken[0.1])
+	}
+	if broken[0] == 0 {
+		t.Fatalf("Expected the track to break without the low tier")
+	}
+}
+
+// Without a gate or an overlap threshold a low confidence detection
+// still has to overlap the predicted box
+func TestLowConfidenceNeedsOverlap(t *testing.T) {
+	cfg := testConfig()
+	cfg.Yolo.ConfidenceThreshold = 0.5
+	cfg.Yolo.LowConfidenceThreshold = 0.1
+	a := newTestAssociator(t, cfg)
+	now := time.Unix(0, 0)
+	for range 5 {
+		stepScored(t, a, now, yolo.Detection{Box: image.Rect(300, 200, 340, 280), Score: 0.9})
+		now = now.Add(test_frame_duration)
+	}
+	stepScored(t, a, now, yolo.Detection{Box: image.Rect(500, 200, 540, 280), Score: 0.3})
+	if status := a.EnumeratePeople()[0].Status(); status != STATUS_LOST {
+		t.Fatalf("Expected the person to be lost, got %s", status)
+	}
+}
+
+func TestLowConfidenceDoesntStartTracks(t *testing.T) {
+	cfg := testConfig()
+	cfg.Yolo.ConfidenceThreshold = 0.5
+	cfg.Yolo.LowConfidenceThreshold = 0.1
+	a := newTestAssociator(t, cfg)
+	now := time.Unix(0, 0)
+	for i := range 5 {
+		stepScored(t, a, now,
+			yolo.Detection{Box: image.Rect(20+i*10, 200, 60+i*10, 280), Score: 0.3},
+			yolo.Detection{Box: image.Rect(400, 200, 440, 280), Score: 0.9},
+		)
+		if a.TotalPeople() != 1 {
+			t.Fatalf("Frame %d: expected only the confident detection to be tracked, got %d people", i, a.TotalPeople())
+		}
+		now = now.Add(test_frame_duration)
+	}
+}
+
+func TestCrossedEdge(t *testing.T) {
+	bounds := image.Rect(0, 0, test_w, test_h)
+	cases := []struct {
+		p       image.Point
+		edge    Edge
+		crossed bool
+	}{
+		{image.Pt(320, 240), "", false},
+		{image.Pt(0, 0), "", false},
+		{image.Pt(test_w-1, test_h-1), "", false},
+		{image.Pt(-5, 240), EDGE_LEFT, true},
+		{image.Pt(test_w, 240), EDGE_RIGHT, true},
+		{image.Pt(320, -1), EDGE_TOP, true},
+		{image.Pt(320, test_h+30), EDGE_BOTTOM, true},
+		// the farther edge wins in the corners
+		{image.Pt(-5, -20), EDGE_TOP, true},
+		{image.Pt(test_w+50, test_h+10), EDGE_RIGHT, true},
+	}
+	for _, c := range cases {
+		if edge, crossed := crossedEdge(c.p, bounds); edge != c.edge || crossed != c.crossed {
+			t.Fatalf("%v: expected %q, %t, got %q, %t", c.p, c.edge, c.crossed, edge, crossed)
+		}
+	}
+}
+
+func TestOutOfBounds(t *testing.T) {
+	cases := []struct {
+		margin uint
+		edge   Edge
+		// walking until the box is gone from the frame
+		start, dx int
+	}{
+		{0, EDGE_RIGHT, 400, 10},
+		{50, EDGE_RIGHT, 400, 10},
+		{0, EDGE_LEFT, 200, -10},
+		{50, EDGE_LEFT, 200, -10},
+	}
+	for _, c := range cases {
+		cfg := testConfig()
+		cfg.Reid.OOBExpireSec = 0.3
+		cfg.Reid.BoundsMargin = c.margin
+		a := newTestAssociator(t, cfg)
+		now := time.Unix(0, 0)
+		var id string
+		for x := c.start; x < test_w && x > -40; x += c.dx {
+			step(t, a, now, actor{red, image.Rect(x, 200, x+40, 280)})
+			id = a.EnumeratePeople()[0].Id()
+			now = now.Add(test_frame_duration)
+		}
+		var exit *Exit
+		for range 10 {
+			step(t, a, now)
+			now = now.Add(test_frame_duration)
+			if a.TotalPeople() == 0 {
+				break
+			}
+			person := a.EnumeratePeople()[0]
+			if person.Id() != id {
+				t.Fatalf("%s margin %d: id changed from %s to %s", c.edge, c.margin, id, person.Id())
+			}
+			if person.Status() == STATUS_OOB {
+				exit = person.Exit()
+				if exported := person.Export(); exported.X >= test_w || exported.Y >= test_h {
+					t.Fatalf("%s margin %d: exported %d,%d outside the frame", c.edge, c.margin, exported.X, exported.Y)
+				}
+			}
+		}
+		if exit == nil {
+			t.Fatalf("%s margin %d: person never went out of bounds", c.edge, c.margin)
+		}
+		// right is 0°, left 180°
+		heading := math.Mod(exit.Direction-direction(image.Pt(c.dx, 0))+540, 360) - 180
+		if exit.Edge != c.edge || math.Abs(heading) > 45 {
+			t.Fatalf("%s margin %d: unexpected exit %+v", c.edge, c.margin, exit)
+		}
+		if a.TotalPeople() != 0 {
+			t.Fatalf("%s margin %d: out of bounds person wasn't retired early", c.edge, c.margin)
+		}
+	}
+}
+
+// Fractions of a second in the config aren't rounded down
+func TestFractionalSeconds(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.ValidateSec = 0.5
+	cfg.Reid.ExpireSec = 1.5
+	a := newTestAssociator(t, cfg)
+	box := image.Rect(300, 200, 340, 280)
+	now := time.Unix(0, 0)
+	for range 4 {
+		step(t, a, now, actor{red, box})
+		now = now.Add(test_frame_duration)
+	}
+	if a.EnumeratePeople()[0].IsValid() {
+		t.Fatalf("Validated after %s, expected 0.5s", 3*test_frame_duration)
+	}
+	for range 4 {
+		step(t, a, now, actor{red, box})
+		now = now.Add(test_frame_duration)
+	}
+	if !a.EnumeratePeople()[0].IsValid() {
+		t.Fatalf("Not validated after %s", 7*test_frame_duration)
+	}
+	last_seen := now.Add(-test_frame_duration)
+	for now.Sub(last_seen) <= 1200*time.Millisecond {
+		step(t, a, now)
+		now = now.Add(test_frame_duration)
+	}
+	if status := a.EnumeratePeople()[0].Status(); status == STATUS_EXPIRED {
+		t.Fatalf("Expired after %s, expected 1.5s", now.Sub(last_seen))
+	}
+	for now.Sub(last_seen) <= 1600*time.Millisecond {
+		step(t, a, now)
+		now = now.Add(test_frame_duration)
+	}
+	if status := a.EnumeratePeople()[0].Status(); status != STATUS_EXPIRED {
+		t.Fatalf("Still %s after %s", status, now.Sub(last_seen))
+	}
+}
+
+// A person showing up right where somebody just expired is a new
+// track, the expired one is done for good
+func TestExpiredStaysExpired(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.SummaryPoints = 10
+	a := newTestAssociator(t, cfg)
+	box := image.Rect(300, 200, 340, 280)
+	now := time.Unix(0, 0)
+	for range 20 {
+		step(t, a, now, actor{red, box})
+		now = now.Add(test_frame_duration)
+	}
+	id := a.EnumeratePeople()[0].Id()
+	last_seen := now.Add(-test_frame_duration)
+	for now.Sub(last_seen) <= 2*time.Second {
+		step(t, a, now)
+		now = now.Add(test_frame_duration)
+	}
+	a.Events()
+	// expires in CleanUp and is detected again in the same frame
+	step(t, a, now, actor{red, box})
+
+	events := a.Events()
+	types := make(map[string][]EventType)
+	for _, event := range events {
+		types[event.Id] = append(types[event.Id], event.Type)
+	}
+	if !slices.Equal(types[id], []EventType{EVENT_EXPIRED}) {
+		t.Fatalf("Expected only an expired event for %s, got %v", id, events)
+	}
+	if len(types) != 2 {
+		t.Fatalf("Expected a new person to be created, got %v", events)
+	}
+	for _, person := range a.EnumeratePeople() {
+		if person.Id() != id {
+			continue
+		}
+		if person.Status() != STATUS_EXPIRED || person.Export().Summary == nil {
+			t.Fatalf("Expected %s to be exported expired with a summary, got %s", id, person.Status())
+		}
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.OOBExpireSec = 0.3
+	a := newTestAssociator(t, cfg)
+	start := time.Unix(0, 0)
+	now := start
+	var events []Event
+	for i := range 40 {
+		x := 300 + i*10
+		// occluded for a couple of frames
+		if i == 15 || i == 16 {
+			step(t, a, now)
+		} else {
+			step(t, a, now, actor{red, image.Rect(x, 200, x+40, 280)})
+		}
+		events = append(events, a.Events()...)
+		now = now.Add(test_frame_duration)
+	}
+	if a.TotalPeople() != 0 {
+		t.Fatalf("Expected the person to be gone, %d left", a.TotalPeople())
+	}
+	expected := []EventType{
+		EVENT_CREATED,
+		EVENT_VALIDATED,
+		EVENT_LOST,
+		EVENT_RECOVERED,
+		EVENT_LOST,
+		EVENT_OUT_OF_BOUNDS,
+		EVENT_EXPIRED,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, got %+v", expected, events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] || event.Id != events[0].Id || event.Class != "person" {
+			t.Fatalf("Event %d: expected %s of %s, got %+v", i, expected[i], events[0].Id, event)
+		}
+		if i > 0 && event.Time.Before(events[i-1].Time) {
+			t.Fatalf("Event %d happened before the previous one: %+v", i, events)
+		}
+	}
+	if events[0].Time != start || events[0].Age != 0 {
+		t.Fatalf("Unexpected creation %+v", events[0])
+	}
+	if recovered := events[3]; recovered.Duration != 2*test_frame_duration {
+		t.Fatalf("Expected to be lost for 2 frames, got %s", recovered.Duration)
+	}
+	if oob := events[5]; oob.Exit == nil || oob.Exit.Edge != EDGE_RIGHT {
+		t.Fatalf("Expected an exit to the right, got %+v", oob)
+	}
+	if expired := events[6]; expired.Age != expired.Time.Sub(start) {
+		t.Fatalf("Expected the age to count from the creation, got %+v", expired)
+	}
+	if exported := events[5].Export(); exported.Event != "out_of_bounds" || exported.Exit == nil || exported.Exit.Edge != "right" {
+		t.Fatalf("Unexpected export %+v", exported)
+	}
+	if len(a.Events()) != 0 {
+		t.Fatalf("Events weren't drained")
+	}
+}
+
+func TestPath(t *testing.T) {
+	cases := []struct {
+		pushed, max_points int
+	}{
+		{0, 8},
+		{1, 8},
+		{6, 8},
+		{7, 8},
+		{8, 8},
+		{100, 8},
+		{1000, 64},
+		{5, 0},
+	}
+	for _, c := range cases {
+		p := newPath(image.Pt(0, 0), c.max_points)
+		for i := 1; i <= c.pushed; i++ {
+			p.push(image.Pt(i*10, 0))
+		}
+		polyline := p.polyline()
+		if len(polyline) > max(2, c.max_points) {
+			t.Fatalf("%+v: %d points over the limit", c, len(polyline))
+		}
+		if polyline[0] != image.Pt(0, 0) || polyline[len(polyline)-1] != image.Pt(c.pushed*10, 0) {
+			t.Fatalf("%+v: lost the ends of %v", c, polyline)
+		}
+		if c.pushed+1 <= c.max_points && len(polyline) != c.pushed+1 {
+			t.Fatalf("%+v: expected every point kept, got %v", c, polyline)
+		}
+		if !almostEqual(p.length, float64(c.pushed*10)) {
+			t.Fatalf("%+v: expected length %d, got %f", c, c.pushed*10, p.length)
+		}
+		// evenly spaced but for the last point
+		for i := 2; i < len(polyline)-1; i++ {
+			if polyline[i].Sub(polyline[i-1]) != polyline[1].Sub(polyline[0]) {
+				t.Fatalf("%+v: uneven points %v", c, polyline)
+			}
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	cfg := testConfig()
+	cfg.Reid.SummaryPoints = 10
+	a := newTestAssociator(t, cfg)
+	start := time.Unix(0, 0)
+	now := start
+	last_seen := start
+	for i := range 30 {
+		step(t, a, now, actor{red, image.Rect(100+i*10, 200, 140+i*10, 280)})
+		if summary := a.EnumeratePeople()[0].Export().Summary; summary != nil {
+			t.Fatalf("Frame %d: summary exported before expiry", i)
+		}
+		last_seen = now
+		now = now.Add(test_frame_duration)
+	}
+	var summary *ExportedSummary
+	for range 40 {
+		step(t, a, now)
+		for _, person := range a.EnumeratePeople() {
+			if exported := person.Export(); exported.Summary != nil {
+				if summary != nil {
+					t.Fatalf("Summary exported twice")
+				}
+				summary = exported.Summary
+			}
+		}
+		now = now.Add(test_frame_duration)
+	}
+	if summary == nil {
+		t.Fatalf("No summary exported")
+	}
+	if !summary.FirstSeen.Equal(start) || !summary.LastSeen.Equal(last_seen) ||
+		!almostEqual(summary.DwellSec, last_seen.Sub(start).Seconds()) {
+		t.Fatalf("Unexpected times %+v", summary)
+	}
+	if summary.Entry != [2]int{120, 240} || summary.Exit[0] < 390 || summary.Exit[0] > 410 {
+		t.Fatalf("Unexpected entry and exit %+v", summary)
+	}
+	if len(summary.Path) > 10 || summary.Path[0] != summary.Entry || summary.Path[len(summary.Path)-1] != summary.Exit {
+		t.Fatalf("Unexpected path %v", summary.Path)
+	}
+	if summary.PathLength < 270 || summary.PathLength > 330 {
+		t.Fatalf("Expected a path of about 290px, got %f", summary.PathLength)
+	}
+}
+
+// Snapshots of people lost behind something are cropped from where
+// they are expected
+func TestLostBox(t *testing.T) {
+	a := newTestAssociator(t, testConfig())
+	now := time.Unix(0, 0)
+	var last image.Rectangle
+	for i := range 10 {
+		last = image.Rect(200+i*10, 200, 240+i*10, 280)
+		step(t, a, now, actor{red, last})
+		now = now.Add(test_frame_duration)
+	}
+	step(t, a, now)
+	person := a.EnumeratePeople()[0]
+	if person.Status() != STATUS_LOST {
+		t.Fatalf("Expected the person to be lost, got %s", person.Status())
+	}
+	box := person.Box()
+	if box.Empty() || box.Min.X <= last.Min.X || box.Intersect(last).Empty() {
+		t.Fatalf("Expected a box a little ahead of %v, got %v", last, box)
+	}
+}
+
+func TestWorldPosition(t *testing.T) {
+	a := newTestAssociator(t, testConfig())
+	now := time.Unix(0, 0)
+	step(t, a, now, actor{red, image.Rect(20, 200, 60, 280)})
+	if exported := a.EnumeratePeople()[0].Export(); exported.World != nil {
+		t.Fatalf("World position exported without calibration: %+v", exported.World)
+	}
+
+	// 100px to a meter
+	h, err := homography.Estimate(
+		[]homography.Point{{X: 0, Y: 0}, {X: 100, Y: 0}, {X: 100, Y: 100}, {X: 0, Y: 100}},
+		[]homography.Point{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}, {X: 0, Y: 1}},
+	)
+	if err != nil {
+		t.Fatalf("Can't calibrate: %s", err)
+	}
+	a = newTestAssociator(t, testConfig())
+	a.Calibrate(h)
+	var world *ExportedWorld
+	for i := range 30 {
+		step(t, a, now, actor{red, image.Rect(20+i*10, 200, 60+i*10, 280)})
+		world = a.EnumeratePeople()[0].Export().World
+		now = now.Add(test_frame_duration)
+	}
+	// the feet of the last box are at 330, 280 walking at 100px/s
+	if world == nil || math.Abs(world.X-3.3) > 0.1 || math.Abs(world.Y-2.8) > 0.05 || math.Abs(world.Speed-1) > 0.1 {
+		t.Fatalf("Expected 1m/s at 3.3, 2.8, got %+v", world)
+	}
+}
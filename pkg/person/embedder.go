@@ -0,0 +1,194 @@
+package person
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/Robogera/detect/pkg/config"
+	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
+	"gocv.io/x/gocv"
+)
+
+// Describes the appearance of image regions
+type Embedder interface {
+	// Returns one descriptor per box. Boxes are within m
+	Embed(m *gocv.Mat, boxes []image.Rectangle) ([][]float32, error)
+}
+
+var (
+	ERR_PREPROCESSING = errors.New("Bad reid preprocessing")
+)
+
+// Embedder running a reid DNN on batches of boxes
+type NetEmbedder struct {
+	net         *gocv.Net
+	conv_params gocv.ImageToBlobParams
+	// per channel divisors applied to the blob, nil for none
+	std               []float32
+	output_layer_name string
+	// 0 for all boxes at once
+	batch_size int
+}
+
+func NewNetEmbedder(net *gocv.Net, cfg *config.ConfigFile) (*NetEmbedder, error) {
+	if !gocvcommon.CheckLayerName(net, cfg.Reid.OutputLayerName) {
+		return nil, fmt.Errorf("Model has no layer %s", cfg.Reid.OutputLayerName)
+	}
+	conv_params, std, err := BlobParams(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &NetEmbedder{
+		net:               net,
+		conv_params:       conv_params,
+		std:               std,
+		output_layer_name: cfg.Reid.OutputLayerName,
+		batch_size:        int(cfg.Reid.BatchSize),
+	}, nil
+}
+
+// Expands per channel values given either once or for each channel
+func perChannel(values []float64, name string) ([]float64, error) {
+	switch len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return []float64{values[0], values[0], values[0]}, nil
+	case 3:
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: %s needs 1 or 3 values, got %d", ERR_PREPROCESSING, name, len(values))
+	}
+}
+
+// Blob conversion parameters of the reid model and the per channel
+// std the blob has to be divided by, which opencv can't do itself
+func BlobParams(cfg *config.ConfigFile) (gocv.ImageToBlobParams, []float32, error) {
+	var params gocv.ImageToBlobParams
+	if cfg.Reid.W == 0 || cfg.Reid.H == 0 {
+		return params, nil, fmt.Errorf("%w: unknown input size %dx%d", ERR_PREPROCESSING, cfg.Reid.W, cfg.Reid.H)
+	}
+	scale_factor := cfg.Reid.ScaleFactor
+	if scale_factor == 0 {
+		scale_factor = 1
+	}
+	var padding gocv.PaddingModeType
+	switch config.PaddingType(cfg.Reid.Padding) {
+	case config.PaddingTypeLetterbox, "":
+		padding = gocv.PaddingModeLetterbox
+	case config.PaddingTypeCrop:
+		padding = gocv.PaddingModeCropCenter
+	case config.PaddingTypeResize:
+		padding = gocv.PaddingModeNull
+	default:
+		return params, nil, fmt.Errorf("%w: unknown padding %s", ERR_PREPROCESSING, cfg.Reid.Padding)
+	}
+	mean, err := perChannel(cfg.Reid.Mean, "mean")
+	if err != nil {
+		return params, nil, err
+	}
+	// opencv subtracts the mean before scaling
+	mean_scalar := gocv.NewScalar(0, 0, 0, 0)
+	if mean != nil {
+		mean_scalar = gocv.NewScalar(mean[0]*scale_factor, mean[1]*scale_factor, mean[2]*scale_factor, 0)
+	}
+	std, err := perChannel(cfg.Reid.Std, "std")
+	if err != nil {
+		return params, nil, err
+	}
+	var std32 []float32
+	for _, v := range std {
+		if v == 0 {
+			return params, nil, fmt.Errorf("%w: zero std", ERR_PREPROCESSING)
+		}
+		std32 = append(std32, float32(v))
+	}
+	params = gocv.NewImageToBlobParams(
+		1.0/scale_factor,
+		image.Pt(int(cfg.Reid.W), int(cfg.Reid.H)),
+		mean_scalar,
+		cfg.Reid.SwapRB,
+		gocv.MatTypeCV32F,
+		gocv.DataLayoutNCHW,
+		padding,
+		gocv.NewScalar(0, 0, 0, 0),
+	)
+	return params, std32, nil
+}
+
+func (e *NetEmbedder) Embed(m *gocv.Mat, boxes []image.Rectangle) ([][]float32, error) {
+	batch_size := e.batch_size
+	if batch_size == 0 {
+		batch_size = len(boxes)
+	}
+	descriptors := make([][]float32, 0, len(boxes))
+	for start := 0; start < len(boxes); start += batch_size {
+		batch, err := e.embed(m, boxes[start:min(start+batch_size, len(boxes))])
+		if err != nil {
+			return nil, err
+		}
+		descriptors = append(descriptors, batch...)
+	}
+	return descriptors, nil
+}
+
+// Runs the crops of boxes through the net as a single NCHW blob
+func (e *NetEmbedder) embed(m *gocv.Mat, boxes []image.Rectangle) ([][]float32, error) {
+	regions := make([]gocv.Mat, 0, len(boxes))
+	defer func() {
+		for _, region := range regions {
+			region.Close()
+		}
+	}()
+	for _, box := range boxes {
+		regions = append(regions, m.Region(box))
+	}
+	blob := gocv.NewMat()
+	defer blob.Close()
+	gocv.BlobFromImagesWithParams(regions, &blob, e.conv_params)
+	if e.std != nil {
+		if err := e.normalize(&blob); err != nil {
+			return nil, err
+		}
+	}
+	e.net.SetInput(blob, "")
+	output := e.net.Forward(e.output_layer_name)
+	defer output.Close()
+	ptr, err := output.DataPtrFloat32()
+	if err != nil {
+		return nil, fmt.Errorf("Can't read descriptors: %w", err)
+	}
+	if len(ptr) == 0 || len(ptr)%len(boxes) != 0 {
+		return nil, fmt.Errorf("Can't split %d values into %d descriptors", len(ptr), len(boxes))
+	}
+	size := len(ptr) / len(boxes)
+	descriptors := make([][]float32, len(boxes))
+	for i := range descriptors {
+		descriptors[i] = make([]float32, size)
+		copy(descriptors[i], ptr[i*size:(i+1)*size])
+	}
+	return descriptors, nil
+}
+
+// Divides every channel plane of an NCHW blob by its std
+func (e *NetEmbedder) normalize(blob *gocv.Mat) error {
+	size := blob.Size()
+	if len(size) != 4 || size[1] != len(e.std) {
+		return fmt.Errorf("%w: blob of shape %v for %d channels", ERR_PREPROCESSING, size, len(e.std))
+	}
+	data, err := blob.DataPtrFloat32()
+	if err != nil {
+		return fmt.Errorf("Can't read blob: %w", err)
+	}
+	plane := size[2] * size[3]
+	for n := range size[0] {
+		for c, std := range e.std {
+			channel := data[(n*size[1]+c)*plane : (n*size[1]+c+1)*plane]
+			for i := range channel {
+				channel[i] /= std
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package person
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
+	"gocv.io/x/gocv"
+)
+
+// Compares per-crop and batched inference on a crowd of 30 people.
+// Needs an onnx reid model with a dynamic batch dimension:
+//
+//	REID_MODEL=/my/reid.onnx go test -run ^$ -bench NetEmbedder ./pkg/person/
+func BenchmarkNetEmbedder(b *testing.B) {
+	path := os.Getenv("REID_MODEL")
+	if path == "" {
+		b.Skip("REID_MODEL is not set")
+	}
+	net, err := gocvcommon.ReadNet("onnx", path, "")
+	if err != nil {
+		b.Fatalf("Can't read model: %s", err)
+	}
+	defer net.Close()
+	output_layer_names := gocvcommon.GetOutputLayerNames(&net)
+	if len(output_layer_names) == 0 {
+		b.Fatalf("Model has no outputs")
+	}
+	cfg := &config.ConfigFile{}
+	cfg.Reid.OutputLayerName = output_layer_names[0]
+	cfg.Reid.W, cfg.Reid.H = 128, 256
+
+	m := gocv.NewMatWithSize(1080, 1920, gocv.MatTypeCV8UC3)
+	defer m.Close()
+	boxes := make([]image.Rectangle, 0, 30)
+	for i := range 30 {
+		box := image.Rect(i%10*190, i/10*350, i%10*190+90, i/10*350+250)
+		gocv.Rectangle(&m, box, nextColor(), -1)
+		boxes = append(boxes, box)
+	}
+
+	for _, batch_size := range []uint{1, 8, 16, 0} {
+		b.Run(fmt.Sprintf("batch_size=%d", batch_size), func(b *testing.B) {
+			cfg.Reid.BatchSize = batch_size
+			embedder, err := NewNetEmbedder(&net, cfg)
+			if err != nil {
+				b.Fatalf("Can't create embedder: %s", err)
+			}
+			// warm up, the first forward pass is way slower
+			if _, err := embedder.Embed(&m, boxes); err != nil {
+				b.Fatalf("Can't embed: %s", err)
+			}
+			b.ResetTimer()
+			for range b.N {
+				if _, err := embedder.Embed(&m, boxes); err != nil {
+					b.Fatalf("Can't embed: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestBlobParams(t *testing.T) {
+	cfg := &config.ConfigFile{}
+	cfg.Reid.W, cfg.Reid.H = 64, 128
+	cfg.Reid.ScaleFactor = 255
+	cfg.Reid.Mean = []float64{0.485, 0.456, 0.406}
+	cfg.Reid.Std = []float64{0.229, 0.224, 0.225}
+	cfg.Reid.SwapRB = true
+	cfg.Reid.Padding = config.PaddingTypeResize
+	params, std, err := BlobParams(cfg)
+	if err != nil {
+		t.Fatalf("Can't build params: %s", err)
+	}
+	if params.Size != image.Pt(64, 128) || params.ScaleFactor != 1.0/255 ||
+		!params.SwapRB || params.PaddingMode != gocv.PaddingModeNull {
+		t.Fatalf("Unexpected params: %+v", params)
+	}
+	// the mean is subtracted from unscaled pixels
+	if math.Abs(params.Mean.Val1-0.485*255) > 1e-9 || math.Abs(params.Mean.Val3-0.406*255) > 1e-9 {
+		t.Fatalf("Unexpected mean: %+v", params.Mean)
+	}
+	if len(std) != 3 || std[1] != float32(0.224) {
+		t.Fatalf("Unexpected std: %v", std)
+	}
+
+	cfg.Reid.Std = []float64{0.5}
+	if _, std, err := BlobParams(cfg); err != nil || len(std) != 3 || std[2] != 0.5 {
+		t.Fatalf("Expected a single std to apply to every channel, got %v (%v)", std, err)
+	}
+	for name, broken := range map[string]func(*config.ConfigFile){
+		"size":    func(cfg *config.ConfigFile) { cfg.Reid.W = 0 },
+		"padding": func(cfg *config.ConfigFile) { cfg.Reid.Padding = "stretch" },
+		"mean":    func(cfg *config.ConfigFile) { cfg.Reid.Mean = []float64{1, 2} },
+		"std":     func(cfg *config.ConfigFile) { cfg.Reid.Std = []float64{1, 0, 1} },
+	} {
+		broken_cfg := *cfg
+		broken(&broken_cfg)
+		if _, _, err := BlobParams(&broken_cfg); !errors.Is(err, ERR_PREPROCESSING) {
+			t.Fatalf("Expected a preprocessing error for bad %s, got %v", name, err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	blob := gocv.NewMatWithSizes([]int{2, 3, 2, 2}, gocv.MatTypeCV32F)
+	defer blob.Close()
+	data, err := blob.DataPtrFloat32()
+	if err != nil {
+		t.Fatalf("Can't read blob: %s", err)
+	}
+	for i := range data {
+		data[i] = 1
+	}
+	e := &NetEmbedder{std: []float32{1, 2, 4}}
+	if err := e.normalize(&blob); err != nil {
+		t.Fatalf("Can't normalize: %s", err)
+	}
+	for i, v := range data {
+		// 4 values per plane, 3 planes per image
+		expected := []float32{1, 0.5, 0.25}[i/4%3]
+		if v != expected {
+			t.Fatalf("Value %d: expected %f, got %f", i, expected, v)
+		}
+	}
+}
+
+// Deterministic embedder for tests: describes a box by its mean
+// color, so differently colored boxes look like different people
+type colorEmbedder struct{}
+
+func (e colorEmbedder) Embed(m *gocv.Mat, boxes []image.Rectangle) ([][]float32, error) {
+	descriptors := make([][]float32, 0, len(boxes))
+	for _, box := range boxes {
+		region := m.Region(box)
+		mean := region.Mean()
+		region.Close()
+		// shifted by one so black boxes don't end up with a zero vector
+		descriptor := []float32{
+			float32(mean.Val1) + 1,
+			float32(mean.Val2) + 1,
+			float32(mean.Val3) + 1,
+		}
+		var norm float32
+		for _, v := range descriptor {
+			norm += v * v
+		}
+		norm = float32(math.Sqrt(float64(norm)))
+		for i := range descriptor {
+			descriptor[i] /= norm
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors, nil
+}
@@ -0,0 +1,54 @@
+package person
+
+import (
+	"image"
+	"time"
+)
+
+type EventType string
+
+const (
+	EVENT_CREATED       EventType = "created"
+	EVENT_VALIDATED     EventType = "validated"
+	EVENT_LOST          EventType = "lost"
+	EVENT_RECOVERED     EventType = "recovered"
+	EVENT_EXPIRED       EventType = "expired"
+	EVENT_OUT_OF_BOUNDS EventType = "out_of_bounds"
+)
+
+// Change in a person's track
+type Event struct {
+	Type  EventType
+	Id    string
+	Class string
+	Time  time.Time
+	// since the person was created
+	Age time.Duration
+	// since the person's previous event, how long it was lost
+	// for EVENT_RECOVERED for example
+	Duration time.Duration
+	Position image.Point
+	// nil unless the person left the frame
+	Exit *Exit
+}
+
+func (a *Associator) emit(p *Person, event_type EventType, t time.Time) {
+	a.events = append(a.events, Event{
+		Type:     event_type,
+		Id:       p.Id(),
+		Class:    p.Class(),
+		Time:     t,
+		Age:      t.Sub(p.created),
+		Duration: t.Sub(p.last_event),
+		Position: p.filter.State(),
+		Exit:     p.exit,
+	})
+	p.last_event = t
+}
+
+// Events since the previous call in the order they happened
+func (a *Associator) Events() []Event {
+	events := a.events
+	a.events = nil
+	return events
+}
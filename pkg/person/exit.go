@@ -0,0 +1,84 @@
+package person
+
+import (
+	"image"
+	"math"
+	"time"
+)
+
+type Edge string
+
+const (
+	EDGE_LEFT   Edge = "left"
+	EDGE_RIGHT  Edge = "right"
+	EDGE_TOP    Edge = "top"
+	EDGE_BOTTOM Edge = "bottom"
+)
+
+// Where and which way a person left the frame
+type Exit struct {
+	Edge Edge
+	// degrees clockwise from the x axis in image coordinates,
+	// 0 for right and 90 for down
+	Direction float64
+	Time      time.Time
+	// last point in bounds, the predicted one is off-screen
+	Position image.Point
+}
+
+// Edge of bounds that p is the farthest beyond, false if p is inside
+func crossedEdge(p image.Point, bounds image.Rectangle) (Edge, bool) {
+	if p.In(bounds) {
+		return "", false
+	}
+	beyond := map[Edge]int{
+		EDGE_LEFT:   bounds.Min.X - p.X,
+		EDGE_RIGHT:  p.X - bounds.Max.X + 1,
+		EDGE_TOP:    bounds.Min.Y - p.Y,
+		EDGE_BOTTOM: p.Y - bounds.Max.Y + 1,
+	}
+	edge, farthest := EDGE_LEFT, beyond[EDGE_LEFT]
+	for _, e := range []Edge{EDGE_RIGHT, EDGE_TOP, EDGE_BOTTOM} {
+		if beyond[e] > farthest {
+			edge, farthest = e, beyond[e]
+		}
+	}
+	return edge, true
+}
+
+// Closest point of r to p
+func clamp(p image.Point, r image.Rectangle) image.Point {
+	return image.Pt(min(max(p.X, r.Min.X), r.Max.X-1), min(max(p.Y, r.Min.Y), r.Max.Y-1))
+}
+
+func direction(v image.Point) float64 {
+	degrees := math.Atan2(float64(v.Y), float64(v.X)) * 180 / math.Pi
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+// Moves a lost person whose predicted position left bounds out of
+// bounds. The position keeps drifting off-screen otherwise
+func (p *Person) checkBounds(t time.Time, bounds image.Rectangle) {
+	if p.last_status != STATUS_LOST {
+		return
+	}
+	edge, crossed := crossedEdge(p.filter.State(), bounds)
+	if !crossed {
+		return
+	}
+	p.exit = &Exit{
+		Edge:      edge,
+		Direction: direction(p.filter.Speed()),
+		Time:      t,
+		Position:  clamp(p.State(), bounds),
+	}
+	p.last_status = STATUS_OOB
+}
+
+// Nil unless the person left the frame
+func (p *Person) Exit() *Exit {
+	return p.exit
+}
@@ -1,15 +1,133 @@
 package person
 
+import (
+	"math"
+	"time"
+)
+
 type ExportedPerson struct {
-	Id string `json:"id"`
-	X  uint   `json:"x"`
-	Y  uint   `json:"y"`
+	Id        string        `json:"id"`
+	Class     string        `json:"class"`
+	X         uint          `json:"x"`
+	Y         uint          `json:"y"`
+	Score     float32       `json:"score"`
+	MeanScore float32       `json:"mean_score"`
+	Exit      *ExportedExit `json:"exit,omitempty"`
+	// once, when a valid person expires
+	Summary *ExportedSummary `json:"summary,omitempty"`
+	// only with calibration
+	World *ExportedWorld `json:"world,omitempty"`
+	// seconds so far in every zone the person is in, by zone name
+	ZoneDwellSec map[string]float64 `json:"zone_dwell_sec,omitempty"`
+}
+
+// Floor position in meters
+type ExportedWorld struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// m/s
+	Speed float64 `json:"speed"`
+}
+
+type ExportedSummary struct {
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	DwellSec   float64   `json:"dwell_sec"`
+	Entry      [2]int    `json:"entry"`
+	Exit       [2]int    `json:"exit"`
+	PathLength float64   `json:"path_length"`
+	Path       [][2]int  `json:"path"`
+}
+
+type ExportedExit struct {
+	Edge      string  `json:"edge"`
+	Direction float64 `json:"direction"`
 }
 
 func (p *Person) Export() *ExportedPerson {
+	position := p.State()
+	var exit *ExportedExit
+	if p.exit != nil {
+		exit = &ExportedExit{
+			Edge:      string(p.exit.Edge),
+			Direction: p.exit.Direction,
+		}
+		// the prediction is off-screen and possibly negative
+		position = p.exit.Position
+	}
+	var summary *ExportedSummary
+	if p.Status() == STATUS_EXPIRED {
+		summary = exportSummary(p.Summary())
+	}
+	var world *ExportedWorld
+	if position, velocity, ok := p.World(); ok {
+		world = &ExportedWorld{
+			X:     position.X,
+			Y:     position.Y,
+			Speed: math.Hypot(velocity.X, velocity.Y),
+		}
+	}
 	return &ExportedPerson{
-		Id: p.Id(),
-		X:  uint(p.State().X),
-		Y:  uint(p.State().Y),
+		Id:        p.Id(),
+		Class:     p.Class(),
+		X:         uint(max(position.X, 0)),
+		Y:         uint(max(position.Y, 0)),
+		Score:     p.Score(),
+		MeanScore: p.MeanScore(),
+		Exit:      exit,
+		Summary:   summary,
+		World:     world,
+	}
+}
+
+func exportSummary(s *Summary) *ExportedSummary {
+	if s == nil {
+		return nil
+	}
+	polyline := make([][2]int, 0, len(s.Path))
+	for _, point := range s.Path {
+		polyline = append(polyline, [2]int{point.X, point.Y})
+	}
+	return &ExportedSummary{
+		FirstSeen:  s.FirstSeen,
+		LastSeen:   s.LastSeen,
+		DwellSec:   s.Dwell.Seconds(),
+		Entry:      [2]int{s.Entry.X, s.Entry.Y},
+		Exit:       [2]int{s.Exit.X, s.Exit.Y},
+		PathLength: s.Length,
+		Path:       polyline,
+	}
+}
+
+type ExportedEvent struct {
+	Event       string        `json:"event"`
+	Id          string        `json:"id"`
+	Class       string        `json:"class"`
+	Time        time.Time     `json:"time"`
+	AgeSec      float64       `json:"age_sec"`
+	DurationSec float64       `json:"duration_sec"`
+	X           int           `json:"x"`
+	Y           int           `json:"y"`
+	Exit        *ExportedExit `json:"exit,omitempty"`
+}
+
+func (e Event) Export() *ExportedEvent {
+	var exit *ExportedExit
+	if e.Exit != nil {
+		exit = &ExportedExit{
+			Edge:      string(e.Exit.Edge),
+			Direction: e.Exit.Direction,
+		}
+	}
+	return &ExportedEvent{
+		Event:       string(e.Type),
+		Id:          e.Id,
+		Class:       e.Class,
+		Time:        e.Time,
+		AgeSec:      e.Age.Seconds(),
+		DurationSec: e.Duration.Seconds(),
+		X:           e.Position.X,
+		Y:           e.Position.Y,
+		Exit:        exit,
 	}
 }
@@ -42,3 +42,14 @@ func generateToken(l uint) string {
 func vecLen(v image.Point) float64 {
 	return math.Sqrt(math.Pow(float64(v.X), 2) + math.Pow(float64(v.Y), 2))
 }
+
+// Intersection over union, 0 for empty boxes
+func iou(a, b image.Rectangle) float64 {
+	intersection := a.Intersect(b)
+	if intersection.Empty() {
+		return 0
+	}
+	i := float64(intersection.Dx() * intersection.Dy())
+	u := float64(a.Dx()*a.Dy()+b.Dx()*b.Dy()) - i
+	return i / u
+}
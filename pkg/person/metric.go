@@ -0,0 +1,65 @@
+package person
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/seq"
+)
+
+var (
+	ERR_METRIC = errors.New("Unknown metric")
+)
+
+// Appearance similarity of a detection to a person's stored
+// descriptors. Descriptors are expected to be of unit length
+type Metric struct {
+	similarity func(a, b []float32) float64
+	aggregate  func(scores []float64) float64
+}
+
+func NewMetric(metric, aggregation string) (*Metric, error) {
+	m := &Metric{}
+	switch config.MetricType(metric) {
+	case config.MetricTypeCosine, "":
+		m.similarity = func(a, b []float32) float64 {
+			return float64(seq.CosSim(a, b))
+		}
+	case config.MetricTypeEuclidean:
+		// unit vectors are at most 2 apart
+		m.similarity = func(a, b []float32) float64 {
+			return 1 - float64(seq.EuclidDist(a, b))/2
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ERR_METRIC, metric)
+	}
+	switch config.AggregationType(aggregation) {
+	case config.AggregationTypeMean, "":
+		m.aggregate = seq.Mean[float64]
+	case config.AggregationTypeMax:
+		m.aggregate = slices.Max[[]float64]
+	default:
+		return nil, fmt.Errorf("%w: aggregation %s", ERR_METRIC, aggregation)
+	}
+	return m, nil
+}
+
+func (m *Metric) Similarity(a, b []float32) float64 {
+	return m.similarity(a, b)
+}
+
+// Similarity of descriptor to the gallery as a whole, 0 for
+// an empty gallery
+func (m *Metric) Score(gallery iter.Seq[[]float32], descriptor []float32) float64 {
+	scores := make([]float64, 0)
+	for stored := range gallery {
+		scores = append(scores, m.similarity(stored, descriptor))
+	}
+	if len(scores) == 0 {
+		return 0
+	}
+	return m.aggregate(scores)
+}
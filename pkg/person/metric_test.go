@@ -0,0 +1,82 @@
+package person
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/seq"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestSimilarity(t *testing.T) {
+	cases := []struct {
+		metric   string
+		a, b     []float32
+		expected float64
+	}{
+		{config.MetricTypeCosine, []float32{1, 0}, []float32{1, 0}, 1},
+		{config.MetricTypeCosine, []float32{1, 0}, []float32{0, 1}, 0},
+		{config.MetricTypeCosine, []float32{1, 0}, []float32{-1, 0}, -1},
+		{config.MetricTypeCosine, []float32{1, 1}, []float32{1, 0}, 1 / math.Sqrt2},
+		// magnitude doesn't matter
+		{config.MetricTypeCosine, []float32{3, 4}, []float32{30, 40}, 1},
+		{config.MetricTypeCosine, []float32{0, 0}, []float32{1, 0}, 0},
+		{config.MetricTypeEuclidean, []float32{1, 0}, []float32{1, 0}, 1},
+		{config.MetricTypeEuclidean, []float32{1, 0}, []float32{-1, 0}, 0},
+		{config.MetricTypeEuclidean, []float32{1, 0}, []float32{0, 1}, 1 - math.Sqrt2/2},
+	}
+	for _, c := range cases {
+		metric, err := NewMetric(c.metric, config.AggregationTypeMean)
+		if err != nil {
+			t.Fatalf("Can't create %s metric: %s", c.metric, err)
+		}
+		if score := metric.Similarity(c.a, c.b); !almostEqual(score, c.expected) {
+			t.Fatalf("%s(%v, %v): expected %f, got %f", c.metric, c.a, c.b, c.expected, score)
+		}
+	}
+}
+
+func TestAggregation(t *testing.T) {
+	gallery := [][]float32{{1, 0}, {0, 1}, seq.Normalize([]float32{1, 1})}
+	descriptor := []float32{1, 0}
+	cases := []struct {
+		aggregation string
+		expected    float64
+	}{
+		{config.AggregationTypeMean, (1 + 0 + 1/math.Sqrt2) / 3},
+		{config.AggregationTypeMax, 1},
+	}
+	for _, c := range cases {
+		metric, err := NewMetric(config.MetricTypeCosine, c.aggregation)
+		if err != nil {
+			t.Fatalf("Can't create metric: %s", err)
+		}
+		if score := metric.Score(slices.Values(gallery), descriptor); !almostEqual(score, c.expected) {
+			t.Fatalf("%s: expected %f, got %f", c.aggregation, c.expected, score)
+		}
+		if score := metric.Score(slices.Values([][]float32{}), descriptor); score != 0 {
+			t.Fatalf("%s: expected 0 for an empty gallery, got %f", c.aggregation, score)
+		}
+	}
+	if _, err := NewMetric("manhattan", ""); !errors.Is(err, ERR_METRIC) {
+		t.Fatalf("Expected an unknown metric error, got %v", err)
+	}
+	if _, err := NewMetric("", "median"); !errors.Is(err, ERR_METRIC) {
+		t.Fatalf("Expected an unknown aggregation error, got %v", err)
+	}
+}
+
+func TestUnitDescriptors(t *testing.T) {
+	if v := seq.Normalize([]float32{3, 4}); !almostEqual(float64(v[0]), 0.6) || !almostEqual(float64(v[1]), 0.8) {
+		t.Fatalf("Unexpected unit vector %v", v)
+	}
+	if v := seq.Normalize([]float32{0, 0}); v[0] != 0 || v[1] != 0 {
+		t.Fatalf("Zero vector changed to %v", v)
+	}
+}
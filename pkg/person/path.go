@@ -0,0 +1,99 @@
+package person
+
+import (
+	"image"
+	"time"
+)
+
+// Every point a person was detected at, thinned out to at most
+// max_points evenly spaced ones as it grows
+type path struct {
+	points []image.Point
+	// points pushed between two kept ones
+	step   int
+	pushed int
+	last   image.Point
+	// over every pushed point
+	length     float64
+	max_points int
+}
+
+func newPath(start image.Point, max_points int) *path {
+	// room for the first and the last point at least
+	max_points = max(2, max_points)
+	return &path{
+		points:     append(make([]image.Point, 0, max_points), start),
+		step:       1,
+		pushed:     1,
+		last:       start,
+		max_points: max_points,
+	}
+}
+
+func (p *path) push(point image.Point) {
+	p.length += vecLen(point.Sub(p.last))
+	p.last = point
+	if p.pushed%p.step == 0 {
+		p.points = append(p.points, point)
+	}
+	p.pushed++
+	if p.size() > p.max_points {
+		thinned := p.points[:0]
+		for i := 0; i < len(p.points); i += 2 {
+			thinned = append(thinned, p.points[i])
+		}
+		p.points = thinned
+		p.step *= 2
+	}
+}
+
+func (p *path) lastKept() bool {
+	return (p.pushed-1)%p.step == 0
+}
+
+// Of the polyline
+func (p *path) size() int {
+	if p.lastKept() {
+		return len(p.points)
+	}
+	return len(p.points) + 1
+}
+
+// Kept points followed by the last one
+func (p *path) polyline() []image.Point {
+	polyline := make([]image.Point, len(p.points), len(p.points)+1)
+	copy(polyline, p.points)
+	if !p.lastKept() {
+		polyline = append(polyline, p.last)
+	}
+	return polyline
+}
+
+// Visit of a person from the first detection to the last one
+type Summary struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Dwell     time.Duration
+	Entry     image.Point
+	Exit      image.Point
+	// in px over every detected point
+	Length float64
+	Path   []image.Point
+}
+
+// Nil unless the person is valid and the path is tracked
+func (p *Person) Summary() *Summary {
+	if !p.valid || p.path == nil {
+		return nil
+	}
+	polyline := p.path.polyline()
+	return &Summary{
+		FirstSeen: p.created,
+		LastSeen:  p.last_update,
+		Dwell:     p.last_update.Sub(p.created),
+		Entry:     polyline[0],
+		Exit:      p.path.last,
+		Length:    p.path.length,
+		Path:      polyline,
+	}
+}
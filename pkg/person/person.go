@@ -8,6 +8,7 @@ import (
 
 	"github.com/Robogera/detect/pkg/gring"
 	"github.com/Robogera/detect/pkg/gsma"
+	"github.com/Robogera/detect/pkg/homography"
 	"github.com/Robogera/detect/pkg/kalman"
 	"github.com/muesli/gamut"
 	"gocv.io/x/gocv"
@@ -26,33 +27,54 @@ func nextColor() color.RGBA {
 	return base_color
 }
 
-func (a *Associator) NewPerson(t time.Time, box image.Rectangle, descriptor []float32) (*Person, error) {
+func (a *Associator) NewPerson(t time.Time, detection *Detection) (*Person, error) {
+	box := detection.Box
 	a.next_color = gamut.HueOffset(a.next_color, 153)
 	r, g, b, _ := a.next_color.RGBA()
 	descriptors := gring.NewRing[[]float32](a.cfg.Reid.TotalDescriptors)
-	descriptors.Push(descriptor)
+	if detection.Descriptor != nil {
+		descriptors.Push(detection.Descriptor)
+	}
 	trajectory := gring.NewRing[image.Point](a.trajectory_points)
 	trajectory.Push(center(box))
+	var full_path *path
+	if a.cfg.Reid.SummaryPoints > 0 {
+		full_path = newPath(center(box), int(a.cfg.Reid.SummaryPoints))
+	}
 	return &Person{
 		id:          generateToken(a.cfg.Reid.TokenLength),
+		class_id:    detection.ClassId,
+		class:       a.classes.Name(detection.ClassId),
+		created:     t,
 		last_update: t,
+		last_event:  t,
 		trajectory:  trajectory,
+		path:        full_path,
+		homography:  a.homography,
 		color:       color.RGBA{uint8(r), uint8(g), uint8(b), 255},
 		descriptors: descriptors,
-		filter:      kalman.NewFilter(center(box), t, a.cfg.Kalman.ProcessNoiseCov, a.cfg.Kalman.MeasNoiseCov),
+		filter:      kalman.NewFilter(box, t, a.motion_model, a.cfg.Kalman.ProcessNoiseDensity, a.cfg.Kalman.MeasNoiseCov),
 		sma:         gsma.NewSMA2d(a.cfg.Reid.SMAWindow),
 		total_hits:  0,
 		valid:       false,
 		last_box:    box,
+		last_score:  detection.Score,
+		mean_score:  detection.Score,
 		last_status: STATUS_NEW,
 	}, nil
 }
 
 type Person struct {
 	id          string
+	class_id    int
+	class       string
 	created     time.Time
 	last_update time.Time
+	last_event  time.Time
 	trajectory  *gring.Ring[image.Point]
+	// nil unless summaries are on
+	path        *path
+	homography  *homography.Homography
 	color       color.RGBA
 	descriptors *gring.Ring[[]float32]
 	filter      *kalman.Filter
@@ -60,13 +82,23 @@ type Person struct {
 	total_hits  uint
 	valid       bool
 	last_box    image.Rectangle
+	last_score  float32
+	// over every detection including the first one
+	mean_score  float32
 	last_status Status
+	// set once the person is out of bounds
+	exit *Exit
 }
 
 func (p *Person) distance(box image.Rectangle) float64 {
 	return vecLen(p.State().Sub(center(box)))
 }
 
+// Where the filter expects the person's box at t
+func (p *Person) predictedBox(t time.Time) image.Rectangle {
+	return p.filter.PredictBox(t)
+}
+
 func (p *Person) validate(t time.Time, validation_duration time.Duration, validation_frames uint) {
 	if !p.valid && t.Sub(p.created) > validation_duration {
 		if p.total_hits > validation_frames {
@@ -76,14 +108,24 @@ func (p *Person) validate(t time.Time, validation_duration time.Duration, valida
 	}
 }
 
-func (p *Person) update(t time.Time, box image.Rectangle, descriptor []float32) error {
+func (p *Person) update(t time.Time, detection *Detection) error {
 	p.total_hits++
-	p.descriptors.Push(descriptor)
-	p.filter.Update(center(box), t)
-	p.trajectory.Push(p.sma.Recalc(p.filter.State()))
+	if detection.Descriptor != nil {
+		p.descriptors.Push(detection.Descriptor)
+	}
+	p.filter.Update(detection.Box, t)
+	position := p.sma.Recalc(p.filter.State())
+	p.trajectory.Push(position)
+	if p.path != nil {
+		p.path.push(position)
+	}
 	p.last_update = t
-	p.last_box = box
+	p.last_box = detection.Box
+	p.last_score = detection.Score
+	p.mean_score += (detection.Score - p.mean_score) / float32(p.total_hits+1)
 	p.last_status = STATUS_ASSOCIATED
+	// back in the frame after all
+	p.exit = nil
 	return nil
 }
 
@@ -93,13 +135,28 @@ func (p *Person) predict(t time.Time, prediction_duration time.Duration) error {
 	}
 	p.trajectory.Push(p.sma.Recalc(p.filter.State()))
 	p.last_box = image.Rect(0, 0, 0, 0)
-	if p.last_status != STATUS_EXPIRED {
+	if p.last_status != STATUS_EXPIRED && p.last_status != STATUS_OOB {
 		p.last_status = STATUS_LOST
 	}
 	return nil
 }
 
+// Floor position of the bottom center of the box and its velocity,
+// false without calibration or beyond the horizon
+func (p *Person) World() (homography.Point, homography.Point, bool) {
+	if p.homography == nil {
+		return homography.Point{}, homography.Point{}, false
+	}
+	foot, v := p.Foot(), p.filter.Velocity()
+	// the bottom moves with the center and half the height
+	return p.homography.ProjectMotion(
+		homography.Point{X: float64(foot.X), Y: float64(foot.Y)},
+		homography.Point{X: v[0], Y: v[1] + v[3]/2},
+	)
+}
+
 func (p *Person) Id() string        { return p.id }
+func (p *Person) Class() string     { return p.class }
 func (p *Person) Color() color.RGBA { return p.color }
 func (p *Person) SinceDetection(t time.Time) time.Duration {
 	return t.Sub(p.last_update)
@@ -119,6 +176,26 @@ func (p *Person) Status() Status {
 	return p.last_status
 }
 
+// Confidence of the last associated detection
+func (p *Person) Score() float32 {
+	return p.last_score
+}
+
+// Bottom center of the filter's box
+func (p *Person) Foot() image.Point {
+	box := p.filter.Box()
+	return image.Pt((box.Min.X+box.Max.X)/2, box.Max.Y)
+}
+
+// The filter's box, predicted while the person is lost
+func (p *Person) Box() image.Rectangle {
+	return p.filter.Box()
+}
+
+func (p *Person) MeanScore() float32 {
+	return p.mean_score
+}
+
 func (p *Person) DrawTrajectory(m *gocv.Mat, w int, alpha uint8) {
 	c := p.Color()
 	c.A = alpha
@@ -0,0 +1,91 @@
+package reorder
+
+import (
+	"time"
+
+	"github.com/Robogera/detect/pkg/gheap"
+	"github.com/Robogera/detect/pkg/indexed"
+)
+
+// Reorder buffer that restores the order of indexed values
+// coming from several concurrent workers.
+// Values are released as soon as they are in order. A missing
+// index is skipped once the next buffered value has been waiting
+// longer than the latency budget or the buffer is full
+type Buffer[T any] struct {
+	queue       gheap.Heap[indexed.Indexed[T]]
+	expected    uint64
+	max_latency time.Duration
+	capacity    int
+	gaps        uint64
+	stale       uint64
+}
+
+func NewBuffer[T any](max_latency time.Duration, capacity uint) *Buffer[T] {
+	queue := gheap.Heap[indexed.Indexed[T]]{}
+	queue.Init()
+	return &Buffer[T]{
+		queue:       queue,
+		max_latency: max_latency,
+		capacity:    int(capacity),
+	}
+}
+
+// Buffers the value. Returns false if the value is older than
+// the last released one (or a duplicate of a buffered one)
+// and was dropped, the caller is responsible for freeing it
+func (b *Buffer[T]) Push(v indexed.Indexed[T]) bool {
+	if v.Id() < b.expected {
+		b.stale++
+		return false
+	}
+	for _, buffered := range b.queue {
+		if buffered.Id() == v.Id() {
+			b.stale++
+			return false
+		}
+	}
+	b.queue.Push(v)
+	return true
+}
+
+// Returns the next value if it is in order or if the gap before it
+// can't be waited for anymore at moment now
+func (b *Buffer[T]) Pop(now time.Time) (indexed.Indexed[T], bool) {
+	var ret indexed.Indexed[T]
+	if b.queue.IsEmpty() {
+		return ret, false
+	}
+	head := b.queue.Peek()
+	if head.Id() > b.expected {
+		if b.capacity > 0 && b.queue.Len() >= b.capacity {
+			// buffer overflow, giving up on the missing values
+		} else if now.Sub(head.Time()) < b.max_latency {
+			return ret, false
+		}
+		b.gaps += head.Id() - b.expected
+	}
+	ret = b.queue.Pop()
+	b.expected = ret.Id() + 1
+	return ret, true
+}
+
+// Moment at which the gap before the oldest buffered value
+// will be skipped. False if nothing is being waited for
+func (b *Buffer[T]) Deadline() (time.Time, bool) {
+	if b.queue.IsEmpty() || b.queue.Peek().Id() == b.expected {
+		return time.Time{}, false
+	}
+	return b.queue.Peek().Time().Add(b.max_latency), true
+}
+
+// Index of the next value to be released
+func (b *Buffer[T]) Expected() uint64 { return b.expected }
+
+// Total amount of indices skipped so far
+func (b *Buffer[T]) Gaps() uint64 { return b.gaps }
+
+// Total amount of values dropped for arriving too late
+func (b *Buffer[T]) Stale() uint64 { return b.stale }
+
+func (b *Buffer[T]) Len() int { return b.queue.Len() }
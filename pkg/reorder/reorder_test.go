@@ -0,0 +1,129 @@
+package reorder
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/Robogera/detect/pkg/indexed"
+)
+
+var t0 = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func frame(id uint64) indexed.Indexed[uint64] {
+	return indexed.NewIndexed("test", id, t0.Add(time.Duration(id)*40*time.Millisecond), id)
+}
+
+func drain(b *Buffer[uint64], now time.Time) []uint64 {
+	ids := make([]uint64, 0)
+	for {
+		v, ok := b.Pop(now)
+		if !ok {
+			return ids
+		}
+		ids = append(ids, v.Value())
+	}
+}
+
+func TestInOrder(t *testing.T) {
+	b := NewBuffer[uint64](time.Second, 0)
+	for id := range uint64(10) {
+		b.Push(frame(id))
+		got := drain(b, frame(id).Time())
+		if len(got) != 1 || got[0] != id {
+			t.Fatalf("Expected [%d] released immediately, got %v", id, got)
+		}
+	}
+	if b.Gaps() != 0 || b.Stale() != 0 {
+		t.Fatalf("Expected no gaps and no stale frames, got %d and %d", b.Gaps(), b.Stale())
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	b := NewBuffer[uint64](time.Second, 0)
+	ids := []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	got := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		b.Push(frame(id))
+		// every frame arrives well within the latency budget
+		got = append(got, drain(b, t0)...)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("Expected %d frames, got %v (pushed %v)", len(ids), got, ids)
+	}
+	for i, id := range got {
+		if uint64(i) != id {
+			t.Fatalf("Frames out of order: %v (pushed %v)", got, ids)
+		}
+	}
+	if b.Gaps() != 0 {
+		t.Fatalf("Expected no gaps, got %d", b.Gaps())
+	}
+}
+
+func TestLostFrame(t *testing.T) {
+	b := NewBuffer[uint64](100*time.Millisecond, 0)
+	b.Push(frame(0))
+	b.Push(frame(2))
+	b.Push(frame(3))
+	if got := drain(b, frame(3).Time()); len(got) != 1 || got[0] != 0 {
+		t.Fatalf("Expected only [0] before the deadline, got %v", got)
+	}
+	deadline, ok := b.Deadline()
+	if !ok || !deadline.Equal(frame(2).Time().Add(100*time.Millisecond)) {
+		t.Fatalf("Unexpected deadline %v (set: %t)", deadline, ok)
+	}
+	got := drain(b, deadline)
+	if len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Fatalf("Expected [2 3] after the deadline, got %v", got)
+	}
+	if b.Gaps() != 1 {
+		t.Fatalf("Expected 1 gap, got %d", b.Gaps())
+	}
+	if _, ok := b.Deadline(); ok {
+		t.Fatalf("Expected no deadline on an empty buffer")
+	}
+}
+
+func TestStaleFrame(t *testing.T) {
+	b := NewBuffer[uint64](100*time.Millisecond, 0)
+	b.Push(frame(0))
+	b.Push(frame(3))
+	now := frame(3).Time().Add(time.Second)
+	if got := drain(b, now); len(got) != 2 {
+		t.Fatalf("Expected [0 3], got %v", got)
+	}
+	// frames 1 and 2 finally arrive after being skipped
+	if b.Push(frame(1)) || b.Push(frame(2)) {
+		t.Fatalf("Expected skipped frames to be rejected")
+	}
+	if !b.Push(frame(4)) || b.Push(frame(4)) {
+		t.Fatalf("Expected duplicate frame to be rejected")
+	}
+	if b.Stale() != 3 || b.Gaps() != 2 {
+		t.Fatalf("Expected 3 stale frames and 2 gaps, got %d and %d", b.Stale(), b.Gaps())
+	}
+	if got := drain(b, now); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("Expected [4], got %v", got)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	b := NewBuffer[uint64](time.Hour, 3)
+	for _, id := range []uint64{1, 2} {
+		b.Push(frame(id))
+		if got := drain(b, t0); len(got) != 0 {
+			t.Fatalf("Expected nothing while waiting for 0, got %v", got)
+		}
+	}
+	// never holds more than capacity
+	b.Push(frame(3))
+	got := drain(b, t0)
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Fatalf("Expected [1 2 3] once full, got %v", got)
+	}
+	if b.Gaps() != 1 || b.Expected() != 4 {
+		t.Fatalf("Expected 1 gap and next frame 4, got %d and %d", b.Gaps(), b.Expected())
+	}
+}
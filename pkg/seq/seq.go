@@ -90,7 +90,37 @@ func CosSim[T Float](a, b []T) T {
 		sum_a += a[i] * a[i]
 		sum_b += b[i] * b[i]
 	}
-	return sum_mul / sum_a / sum_b
+	if sum_a == 0 || sum_b == 0 {
+		return 0
+	}
+	return sum_mul / T(math.Sqrt(float64(sum_a)*float64(sum_b)))
+}
+
+func EuclidDist[T Float](a, b []T) T {
+	if len(a) != len(b) {
+		return T(math.Inf(1))
+	}
+	var sum T
+	for i := range len(a) {
+		sum += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return T(math.Sqrt(float64(sum)))
+}
+
+// Scales s to unit length in place. Zero vectors are left as is
+func Normalize[T Float](s []T) []T {
+	var sum T
+	for _, v := range s {
+		sum += v * v
+	}
+	if sum == 0 {
+		return s
+	}
+	norm := T(math.Sqrt(float64(sum)))
+	for i := range s {
+		s[i] /= norm
+	}
+	return s
 }
 
 func Sum[T cmp.Ordered](s []T) (sum T) {
@@ -100,6 +130,14 @@ func Sum[T cmp.Ordered](s []T) (sum T) {
 	return
 }
 
+func Mean[T Float | Int](s []T) float64 {
+	var mean float64
+	for _, v := range s {
+		mean += float64(v)
+	}
+	return mean / float64(len(s))
+}
+
 func SqrtMean[T Float | Int](s []T) float64 {
 	var mean float64
 	for _, v := range s {
@@ -0,0 +1,71 @@
+package seq
+
+import (
+	"math"
+	"testing"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestCosSim(t *testing.T) {
+	cases := []struct {
+		a, b     []float32
+		expected float64
+	}{
+		{[]float32{1, 0}, []float32{1, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 0}, []float32{-1, 0}, -1},
+		{[]float32{1, 1}, []float32{1, 0}, 1 / math.Sqrt2},
+		// magnitude doesn't matter
+		{[]float32{3, 4}, []float32{30, 40}, 1},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+		{[]float32{1, 0}, []float32{1, 0, 0}, 0},
+	}
+	for _, c := range cases {
+		if sim := CosSim(c.a, c.b); !almostEqual(float64(sim), c.expected) {
+			t.Fatalf("%v, %v: expected %f, got %f", c.a, c.b, c.expected, sim)
+		}
+	}
+}
+
+func TestEuclidDist(t *testing.T) {
+	cases := []struct {
+		a, b     []float64
+		expected float64
+	}{
+		{[]float64{1, 0}, []float64{1, 0}, 0},
+		{[]float64{1, 0}, []float64{-1, 0}, 2},
+		{[]float64{0, 0}, []float64{3, 4}, 5},
+		{[]float64{1, 0}, []float64{1, 0, 0}, math.Inf(1)},
+	}
+	for _, c := range cases {
+		if d := EuclidDist(c.a, c.b); d != c.expected && !almostEqual(d, c.expected) {
+			t.Fatalf("%v, %v: expected %f, got %f", c.a, c.b, c.expected, d)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		s, expected []float32
+	}{
+		{[]float32{3, 4}, []float32{0.6, 0.8}},
+		{[]float32{0, -2}, []float32{0, -1}},
+		// zero vectors are left as is
+		{[]float32{0, 0}, []float32{0, 0}},
+		{[]float32{}, []float32{}},
+	}
+	for _, c := range cases {
+		v := Normalize(append([]float32(nil), c.s...))
+		if len(v) != len(c.expected) {
+			t.Fatalf("%v: expected %v, got %v", c.s, c.expected, v)
+		}
+		for i := range v {
+			if !almostEqual(float64(v[i]), float64(c.expected[i])) {
+				t.Fatalf("%v: expected %v, got %v", c.s, c.expected, v)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/Robogera/detect/pkg/person"
+	"github.com/Robogera/detect/pkg/zones"
 )
 
 type Event struct {
@@ -21,7 +22,13 @@ type Message struct {
 }
 
 type Parameters struct {
+	Camera     string                   `json:"camera"`
 	Detections []*person.ExportedPerson `json:"detections"`
+	Zones      []zones.ZoneCount        `json:"zones,omitempty"`
+	Lines      []zones.LineCount        `json:"lines,omitempty"`
+	// only on the events topic
+	Events     []*person.ExportedEvent `json:"events,omitempty"`
+	ZoneEvents []*zones.ExportedEvent  `json:"zone_events,omitempty"`
 }
 
 func (c *Event) ToPayload() ([]byte, error) {
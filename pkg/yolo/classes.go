@@ -0,0 +1,107 @@
+package yolo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+var (
+	ERR_CLASS = errors.New("Unknown class")
+)
+
+// Tracked classes keyed by the model's class id
+type Classes map[int]string
+
+func (c Classes) Contains(class_id int) bool {
+	_, ok := c[class_id]
+	return ok
+}
+
+func (c Classes) Name(class_id int) string {
+	if name, ok := c[class_id]; ok {
+		return name
+	}
+	return strconv.Itoa(class_id)
+}
+
+// Reads class names from a file with one name per line,
+// the line number being the class id
+func LoadLabels(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open labels file %s: %w", path, err)
+	}
+	defer file.Close()
+	var labels []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		labels = append(labels, strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can't read labels file %s: %w", path, err)
+	}
+	// trailing empty lines don't count as classes
+	for len(labels) > 0 && labels[len(labels)-1] == "" {
+		labels = labels[:len(labels)-1]
+	}
+	return labels, nil
+}
+
+// Maps configured class names or indices to the model's class ids
+func ResolveClasses(entries []string, labels []string) (Classes, error) {
+	classes := make(Classes, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if class_id, err := strconv.Atoi(entry); err == nil {
+			if class_id < 0 || (len(labels) > 0 && class_id >= len(labels)) {
+				return nil, fmt.Errorf("%w: index %d", ERR_CLASS, class_id)
+			}
+			if class_id < len(labels) {
+				classes[class_id] = labels[class_id]
+			} else {
+				classes[class_id] = entry
+			}
+			continue
+		}
+		found := false
+		for class_id, label := range labels {
+			if strings.EqualFold(label, entry) {
+				classes[class_id] = label
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ERR_CLASS, entry)
+		}
+	}
+	return classes, nil
+}
+
+// Classes to track according to the config. Falls back to the
+// person class index if no classes are configured
+func NewClasses(cfg *config.ConfigFile) (Classes, error) {
+	labels := cfg.Yolo.Labels
+	if len(labels) == 0 && cfg.Yolo.LabelsPath != "" {
+		var err error
+		labels, err = LoadLabels(cfg.Yolo.LabelsPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Yolo.Classes) == 0 {
+		class_id := int(cfg.Yolo.PersonClassIndex)
+		name := "person"
+		if class_id < len(labels) {
+			name = labels[class_id]
+		}
+		return Classes{class_id: name}, nil
+	}
+	return ResolveClasses(cfg.Yolo.Classes, labels)
+}
@@ -0,0 +1,75 @@
+package yolo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+func writeLabels(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "coco.names")
+	err := os.WriteFile(path, []byte("person\nbicycle\ncar\n\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Can't write labels: %s", err)
+	}
+	return path
+}
+
+func TestLoadLabels(t *testing.T) {
+	labels, err := LoadLabels(writeLabels(t))
+	if err != nil {
+		t.Fatalf("Can't load labels: %s", err)
+	}
+	if len(labels) != 3 || labels[0] != "person" || labels[2] != "car" {
+		t.Fatalf("Unexpected labels: %q", labels)
+	}
+}
+
+func TestResolveClasses(t *testing.T) {
+	labels := []string{"person", "bicycle", "car"}
+	classes, err := ResolveClasses([]string{"Person", "2"}, labels)
+	if err != nil {
+		t.Fatalf("Can't resolve: %s", err)
+	}
+	if len(classes) != 2 || classes[0] != "person" || classes[2] != "car" {
+		t.Fatalf("Unexpected classes: %v", classes)
+	}
+	if classes.Contains(1) || classes.Name(1) != "1" {
+		t.Fatalf("Class 1 shouldn't be tracked: %v", classes)
+	}
+	if _, err := ResolveClasses([]string{"truck"}, labels); !errors.Is(err, ERR_CLASS) {
+		t.Fatalf("Expected an unknown class error, got %v", err)
+	}
+	if _, err := ResolveClasses([]string{"3"}, labels); !errors.Is(err, ERR_CLASS) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	// indices work without labels
+	classes, err = ResolveClasses([]string{"7"}, nil)
+	if err != nil || classes[7] != "7" {
+		t.Fatalf("Unexpected classes: %v (%v)", classes, err)
+	}
+}
+
+func TestNewClasses(t *testing.T) {
+	cfg := new(config.ConfigFile)
+	cfg.Yolo.PersonClassIndex = 1
+	classes, err := NewClasses(cfg)
+	if err != nil || len(classes) != 1 || classes[1] != "person" {
+		t.Fatalf("Expected the person class fallback, got %v (%v)", classes, err)
+	}
+	cfg.Yolo.LabelsPath = writeLabels(t)
+	cfg.Yolo.Classes = []string{"car", "bicycle"}
+	classes, err = NewClasses(cfg)
+	if err != nil || len(classes) != 2 || classes[2] != "car" || classes[1] != "bicycle" {
+		t.Fatalf("Unexpected classes: %v (%v)", classes, err)
+	}
+	// labels read from the model win over the file
+	cfg.Yolo.Labels = []string{"car", "bicycle"}
+	classes, err = NewClasses(cfg)
+	if err != nil || len(classes) != 2 || classes[0] != "car" || classes[1] != "bicycle" {
+		t.Fatalf("Unexpected classes: %v (%v)", classes, err)
+	}
+}
@@ -0,0 +1,225 @@
+package yolo
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/Robogera/detect/pkg/config"
+	"gocv.io/x/gocv"
+)
+
+var (
+	ERR_SHAPE   = errors.New("Unexpected output shape")
+	ERR_DECODER = errors.New("Unknown decoder")
+)
+
+// Single candidate found by a decoder
+type Detection struct {
+	Box     image.Rectangle
+	Score   float32
+	ClassId int
+}
+
+// Turns the raw output tensors of a model into candidates
+// in the blob's coordinate space
+type OutputDecoder interface {
+	// size is the size of the blob fed to the model
+	Decode(outputs []gocv.Mat, size image.Point) ([]Detection, error)
+	// True for end-to-end models that already ran NMS
+	HasNMS() bool
+}
+
+func NewDecoder(cfg *config.ConfigFile) (OutputDecoder, error) {
+	switch config.DecoderType(cfg.Yolo.Decoder) {
+	case config.DecoderTypeYoloV5, config.DecoderTypeYoloV7:
+		return &YoloV5Decoder{}, nil
+	case config.DecoderTypeYoloV8:
+		return &YoloV8Decoder{Transpose: true}, nil
+	case config.DecoderTypeYoloV10:
+		return &YoloV10Decoder{}, nil
+	case config.DecoderTypeSSD:
+		return &SSDDecoder{}, nil
+	case "":
+		// legacy behaviour: center, size and class scores
+		// with transposition up to the user
+		return &YoloV8Decoder{Transpose: cfg.Yolo.Transpose}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ERR_DECODER, cfg.Yolo.Decoder)
+}
+
+// 2d view of an output tensor, batch dimensions of size 1 are dropped.
+// Every row is a candidate and every column is one of its attributes
+type table struct {
+	data       []float32
+	rows, cols int
+	transposed bool
+}
+
+func newTable(output gocv.Mat, transposed bool) (table, error) {
+	dims := output.Size()
+	for len(dims) > 2 && dims[0] == 1 {
+		dims = dims[1:]
+	}
+	if len(dims) != 2 {
+		return table{}, fmt.Errorf("%w: %v", ERR_SHAPE, output.Size())
+	}
+	data, err := output.DataPtrFloat32()
+	if err != nil {
+		return table{}, err
+	}
+	rows, cols := dims[0], dims[1]
+	if transposed {
+		rows, cols = cols, rows
+	}
+	return table{data: data, rows: rows, cols: cols, transposed: transposed}, nil
+}
+
+func (t table) at(r, c int) float32 {
+	if t.transposed {
+		return t.data[c*t.rows+r]
+	}
+	return t.data[r*t.cols+c]
+}
+
+// Index and value of the highest score in columns from:cols of row r
+func (t table) argmax(r, from int) (int, float32) {
+	class_id, score := 0, t.at(r, from)
+	for c := from + 1; c < t.cols; c++ {
+		if v := t.at(r, c); v > score {
+			class_id, score = c-from, v
+		}
+	}
+	return class_id, score
+}
+
+func centerBox(x, y, w, h float32) image.Rectangle {
+	return image.Rect(
+		int(x-w/2), int(y-h/2),
+		int(x+w/2), int(y+h/2),
+	)
+}
+
+// [1, candidates, 5+classes] outputs of YOLOv5 and YOLOv7:
+// box center and size, objectness and per class scores
+type YoloV5Decoder struct{}
+
+func (d *YoloV5Decoder) HasNMS() bool { return false }
+
+func (d *YoloV5Decoder) Decode(outputs []gocv.Mat, size image.Point) ([]Detection, error) {
+	var detections []Detection
+	for _, output := range outputs {
+		t, err := newTable(output, false)
+		if err != nil {
+			return nil, err
+		}
+		if t.cols < 6 {
+			return nil, fmt.Errorf("%w: %v", ERR_SHAPE, output.Size())
+		}
+		for r := range t.rows {
+			class_id, score := t.argmax(r, 5)
+			detections = append(detections, Detection{
+				Box:     centerBox(t.at(r, 0), t.at(r, 1), t.at(r, 2), t.at(r, 3)),
+				Score:   score * t.at(r, 4),
+				ClassId: class_id,
+			})
+		}
+	}
+	return detections, nil
+}
+
+// [1, 4+classes, candidates] outputs of YOLOv8 and newer ultralytics
+// models: box center and size and per class scores, no objectness.
+// Set Transpose to false for [1, candidates, 4+classes] layouts
+type YoloV8Decoder struct {
+	Transpose bool
+}
+
+func (d *YoloV8Decoder) HasNMS() bool { return false }
+
+func (d *YoloV8Decoder) Decode(outputs []gocv.Mat, size image.Point) ([]Detection, error) {
+	var detections []Detection
+	for _, output := range outputs {
+		t, err := newTable(output, d.Transpose)
+		if err != nil {
+			return nil, err
+		}
+		if t.cols < 5 {
+			return nil, fmt.Errorf("%w: %v", ERR_SHAPE, output.Size())
+		}
+		for r := range t.rows {
+			class_id, score := t.argmax(r, 4)
+			detections = append(detections, Detection{
+				Box:     centerBox(t.at(r, 0), t.at(r, 1), t.at(r, 2), t.at(r, 3)),
+				Score:   score,
+				ClassId: class_id,
+			})
+		}
+	}
+	return detections, nil
+}
+
+// [1, candidates, 6] outputs of end-to-end YOLOv10 models:
+// box corners, score and class id
+type YoloV10Decoder struct{}
+
+func (d *YoloV10Decoder) HasNMS() bool { return true }
+
+func (d *YoloV10Decoder) Decode(outputs []gocv.Mat, size image.Point) ([]Detection, error) {
+	var detections []Detection
+	for _, output := range outputs {
+		t, err := newTable(output, false)
+		if err != nil {
+			return nil, err
+		}
+		if t.cols != 6 {
+			return nil, fmt.Errorf("%w: %v", ERR_SHAPE, output.Size())
+		}
+		for r := range t.rows {
+			detections = append(detections, Detection{
+				Box: image.Rect(
+					int(t.at(r, 0)), int(t.at(r, 1)),
+					int(t.at(r, 2)), int(t.at(r, 3)),
+				),
+				Score:   t.at(r, 4),
+				ClassId: int(t.at(r, 5)),
+			})
+		}
+	}
+	return detections, nil
+}
+
+// [1, 1, candidates, 7] outputs of SSD-style models (DetectionOutput layer):
+// image id, class id, score and box corners normalized to the blob size
+type SSDDecoder struct{}
+
+func (d *SSDDecoder) HasNMS() bool { return true }
+
+func (d *SSDDecoder) Decode(outputs []gocv.Mat, size image.Point) ([]Detection, error) {
+	var detections []Detection
+	w, h := float32(size.X), float32(size.Y)
+	for _, output := range outputs {
+		t, err := newTable(output, false)
+		if err != nil {
+			return nil, err
+		}
+		if t.cols != 7 {
+			return nil, fmt.Errorf("%w: %v", ERR_SHAPE, output.Size())
+		}
+		for r := range t.rows {
+			// negative image id marks the end of the valid detections
+			if t.at(r, 0) < 0 {
+				break
+			}
+			detections = append(detections, Detection{
+				Box: image.Rect(
+					int(t.at(r, 3)*w), int(t.at(r, 4)*h),
+					int(t.at(r, 5)*w), int(t.at(r, 6)*h),
+				),
+				Score:   t.at(r, 2),
+				ClassId: int(t.at(r, 1)),
+			})
+		}
+	}
+	return detections, nil
+}
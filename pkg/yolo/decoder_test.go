@@ -0,0 +1,164 @@
+package yolo
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+	"gocv.io/x/gocv"
+)
+
+// Builds a float tensor of the given shape from row-major data
+func tensor(t *testing.T, sizes []int, data []float32) gocv.Mat {
+	m := gocv.NewMatWithSizes(sizes, gocv.MatTypeCV32F)
+	ptr, err := m.DataPtrFloat32()
+	if err != nil {
+		t.Fatalf("Can't access tensor data: %s", err)
+	}
+	if len(ptr) != len(data) {
+		t.Fatalf("Tensor of shape %v can't hold %d values", sizes, len(data))
+	}
+	copy(ptr, data)
+	return m
+}
+
+func expect(t *testing.T, got []Detection, want []Detection) {
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d detections, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Box != want[i].Box || got[i].ClassId != want[i].ClassId ||
+			got[i].Score < want[i].Score-1e-5 || got[i].Score > want[i].Score+1e-5 {
+			t.Fatalf("Detection %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+var size = image.Pt(640, 640)
+
+func TestYoloV5Decoder(t *testing.T) {
+	// cx, cy, w, h, objectness, 3 class scores
+	output := tensor(t, []int{1, 2, 8}, []float32{
+		100, 200, 20, 40, 0.5, 0.1, 0.8, 0.1,
+		300, 300, 10, 10, 0.9, 0.9, 0.0, 0.1,
+	})
+	defer output.Close()
+	got, err := (&YoloV5Decoder{}).Decode([]gocv.Mat{output}, size)
+	if err != nil {
+		t.Fatalf("Can't decode: %s", err)
+	}
+	expect(t, got, []Detection{
+		{Box: image.Rect(90, 180, 110, 220), Score: 0.4, ClassId: 1},
+		{Box: image.Rect(295, 295, 305, 305), Score: 0.81, ClassId: 0},
+	})
+}
+
+func TestYoloV8Decoder(t *testing.T) {
+	// ultralytics layout: one column per candidate
+	// rows are cx, cy, w, h and 2 class scores
+	output := tensor(t, []int{1, 6, 3}, []float32{
+		100, 300, 50,
+		200, 300, 50,
+		20, 10, 4,
+		40, 10, 4,
+		0.7, 0.1, 0.2,
+		0.2, 0.6, 0.3,
+	})
+	defer output.Close()
+	got, err := (&YoloV8Decoder{Transpose: true}).Decode([]gocv.Mat{output}, size)
+	if err != nil {
+		t.Fatalf("Can't decode: %s", err)
+	}
+	expect(t, got, []Detection{
+		{Box: image.Rect(90, 180, 110, 220), Score: 0.7, ClassId: 0},
+		{Box: image.Rect(295, 295, 305, 305), Score: 0.6, ClassId: 1},
+		{Box: image.Rect(48, 48, 52, 52), Score: 0.3, ClassId: 1},
+	})
+
+	untransposed := tensor(t, []int{1, 1, 6}, []float32{100, 200, 20, 40, 0.7, 0.2})
+	defer untransposed.Close()
+	got, err = (&YoloV8Decoder{Transpose: false}).Decode([]gocv.Mat{untransposed}, size)
+	if err != nil {
+		t.Fatalf("Can't decode: %s", err)
+	}
+	expect(t, got, []Detection{
+		{Box: image.Rect(90, 180, 110, 220), Score: 0.7, ClassId: 0},
+	})
+}
+
+func TestYoloV10Decoder(t *testing.T) {
+	// x1, y1, x2, y2, score, class id
+	output := tensor(t, []int{1, 2, 6}, []float32{
+		90, 180, 110, 220, 0.9, 2,
+		0, 0, 0, 0, 0.0, 0,
+	})
+	defer output.Close()
+	d := &YoloV10Decoder{}
+	if !d.HasNMS() {
+		t.Fatalf("End-to-end decoder should skip NMS")
+	}
+	got, err := d.Decode([]gocv.Mat{output}, size)
+	if err != nil {
+		t.Fatalf("Can't decode: %s", err)
+	}
+	expect(t, got, []Detection{
+		{Box: image.Rect(90, 180, 110, 220), Score: 0.9, ClassId: 2},
+		{Box: image.Rect(0, 0, 0, 0), Score: 0, ClassId: 0},
+	})
+}
+
+func TestSSDDecoder(t *testing.T) {
+	// image id, class id, score, normalized x1, y1, x2, y2
+	output := tensor(t, []int{1, 1, 3, 7}, []float32{
+		0, 15, 0.95, 0.25, 0.5, 0.5, 1.0,
+		0, 7, 0.5, 0.0, 0.0, 0.125, 0.125,
+		-1, 0, 0, 0, 0, 0, 0,
+	})
+	defer output.Close()
+	got, err := (&SSDDecoder{}).Decode([]gocv.Mat{output}, image.Pt(320, 160))
+	if err != nil {
+		t.Fatalf("Can't decode: %s", err)
+	}
+	expect(t, got, []Detection{
+		{Box: image.Rect(80, 80, 160, 160), Score: 0.95, ClassId: 15},
+		{Box: image.Rect(0, 0, 40, 20), Score: 0.5, ClassId: 7},
+	})
+}
+
+func TestBadShape(t *testing.T) {
+	output := tensor(t, []int{1, 2, 5}, make([]float32, 10))
+	defer output.Close()
+	if _, err := (&YoloV10Decoder{}).Decode([]gocv.Mat{output}, size); !errors.Is(err, ERR_SHAPE) {
+		t.Fatalf("Expected a shape error, got %v", err)
+	}
+	if _, err := (&YoloV5Decoder{}).Decode([]gocv.Mat{output}, size); !errors.Is(err, ERR_SHAPE) {
+		t.Fatalf("Expected a shape error, got %v", err)
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	cfg := new(config.ConfigFile)
+	for decoder, want := range map[string]OutputDecoder{
+		"v5":  &YoloV5Decoder{},
+		"v7":  &YoloV5Decoder{},
+		"v8":  &YoloV8Decoder{Transpose: true},
+		"v10": &YoloV10Decoder{},
+		"ssd": &SSDDecoder{},
+		"":    &YoloV8Decoder{Transpose: false},
+	} {
+		cfg.Yolo.Decoder = decoder
+		got, err := NewDecoder(cfg)
+		if err != nil {
+			t.Fatalf("Can't create decoder %q: %s", decoder, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Decoder %q: expected %#v, got %#v", decoder, want, got)
+		}
+	}
+	cfg.Yolo.Decoder = "v3"
+	if _, err := NewDecoder(cfg); !errors.Is(err, ERR_DECODER) {
+		t.Fatalf("Expected an unknown decoder error, got %v", err)
+	}
+}
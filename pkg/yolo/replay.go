@@ -0,0 +1,185 @@
+package yolo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Robogera/detect/pkg/config"
+	"gocv.io/x/gocv"
+)
+
+var (
+	ERR_REPLAY_FORMAT = errors.New("Unknown replay format")
+	ERR_REPLAY_LINE   = errors.New("Malformed replay line")
+)
+
+// One line of an NDJSON replay file. Frames without a camera
+// apply to every camera
+type ReplayFrame struct {
+	Camera     string            `json:"camera,omitempty"`
+	Frame      uint64            `json:"frame"`
+	Detections []ReplayDetection `json:"detections"`
+}
+
+type ReplayDetection struct {
+	// x1, y1, x2, y2 in frame pixels
+	Box   [4]int  `json:"box"`
+	Score float32 `json:"score"`
+	Class int     `json:"class"`
+}
+
+// Detector that ignores the frames and plays back detections
+// recorded earlier, keyed by camera and frame id
+type ReplayDetector struct {
+	// "" holds the frames of any camera
+	frames map[string]map[uint64][]Detection
+	// nil to play back every detection
+	classes   Classes
+	threshold float32
+}
+
+// Plays back the detections of the tracked classes scoring above
+// either confidence threshold, like NetDetector would have kept
+func NewReplayDetector(cfg *config.ConfigFile) (*ReplayDetector, error) {
+	classes, err := NewClasses(cfg)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(cfg.Yolo.ReplayPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open replay file %s: %w", cfg.Yolo.ReplayPath, err)
+	}
+	defer file.Close()
+	var d *ReplayDetector
+	switch config.ReplayFormat(cfg.Yolo.ReplayFormat) {
+	case config.ReplayFormatNDJSON, "":
+		d, err = ReadNDJSON(file)
+	case config.ReplayFormatMOT:
+		d, err = ReadMOT(file, motClass(cfg, classes))
+	default:
+		return nil, fmt.Errorf("%w: %s", ERR_REPLAY_FORMAT, cfg.Yolo.ReplayFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Can't read replay file %s: %w", cfg.Yolo.ReplayPath, err)
+	}
+	d.classes = classes
+	d.threshold = candidateThreshold(cfg)
+	return d, nil
+}
+
+// The tracked class named person if there is one, MOT files only
+// have people
+func motClass(cfg *config.ConfigFile, classes Classes) int {
+	for class_id, name := range classes {
+		if name == "person" {
+			return class_id
+		}
+	}
+	return int(cfg.Yolo.PersonClassIndex)
+}
+
+func (d *ReplayDetector) add(camera string, frame uint64, detection Detection) {
+	if d.frames[camera] == nil {
+		d.frames[camera] = make(map[uint64][]Detection)
+	}
+	d.frames[camera][frame] = append(d.frames[camera][frame], detection)
+}
+
+// Reads one ReplayFrame per line
+func ReadNDJSON(r io.Reader) (*ReplayDetector, error) {
+	d := &ReplayDetector{frames: make(map[string]map[uint64][]Detection)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var frame ReplayFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, fmt.Errorf("%w %d: %w", ERR_REPLAY_LINE, line, err)
+		}
+		if len(frame.Detections) == 0 && d.frames[frame.Camera] == nil {
+			d.frames[frame.Camera] = make(map[uint64][]Detection)
+		}
+		for _, detection := range frame.Detections {
+			d.add(frame.Camera, frame.Frame, Detection{
+				Box:     image.Rect(detection.Box[0], detection.Box[1], detection.Box[2], detection.Box[3]),
+				Score:   detection.Score,
+				ClassId: detection.Class,
+			})
+		}
+	}
+	return d, scanner.Err()
+}
+
+// Reads MOTChallenge det.txt/gt.txt style lines:
+// frame, id, left, top, width, height, confidence, ...
+// MOT frames start at 1 while ours start at 0. The format
+// has no classes so every detection gets class_id
+func ReadMOT(r io.Reader, class_id int) (*ReplayDetector, error) {
+	d := &ReplayDetector{frames: make(map[string]map[uint64][]Detection)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("%w %d: %d fields", ERR_REPLAY_LINE, line, len(fields))
+		}
+		values := make([]float64, 7)
+		for i := range values {
+			v, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w %d: %w", ERR_REPLAY_LINE, line, err)
+			}
+			values[i] = v
+		}
+		if values[0] < 1 {
+			return nil, fmt.Errorf("%w %d: frame %v", ERR_REPLAY_LINE, line, values[0])
+		}
+		left, top := int(values[2]), int(values[3])
+		d.add("", uint64(values[0])-1, Detection{
+			Box:     image.Rect(left, top, left+int(values[4]), top+int(values[5])),
+			Score:   float32(values[6]),
+			ClassId: class_id,
+		})
+	}
+	return d, scanner.Err()
+}
+
+func (d *ReplayDetector) Detect(img *gocv.Mat, source string, id uint64) ([]Detection, error) {
+	frames, ok := d.frames[source]
+	if !ok {
+		frames = d.frames[""]
+	}
+	recorded := frames[id]
+	// callers are free to modify the detections
+	detections := make([]Detection, 0, len(recorded))
+	for _, detection := range recorded {
+		if d.classes != nil && (!d.classes.Contains(detection.ClassId) || detection.Score < d.threshold) {
+			continue
+		}
+		detections = append(detections, detection)
+	}
+	return detections, nil
+}
+
+// Total amount of recorded frames with detections
+func (d *ReplayDetector) Len() int {
+	total := 0
+	for _, frames := range d.frames {
+		total += len(frames)
+	}
+	return total
+}
+
+func (d *ReplayDetector) Close() error { return nil }
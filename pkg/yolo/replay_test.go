@@ -0,0 +1,113 @@
+package yolo
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+func TestReadNDJSON(t *testing.T) {
+	d, err := ReadNDJSON(strings.NewReader(`
+{"frame":0,"camera":"left","detections":[{"box":[10,20,30,60],"score":0.9,"class":0},{"box":[1,1,2,2],"score":0.5,"class":2}]}
+{"frame":1,"camera":"left","detections":[]}
+{"frame":0,"detections":[{"box":[5,5,15,15],"score":0.7,"class":0}]}
+`))
+	if err != nil {
+		t.Fatalf("Can't read: %s", err)
+	}
+	detections, err := d.Detect(nil, "left", 0)
+	if err != nil {
+		t.Fatalf("Can't detect: %s", err)
+	}
+	if len(detections) != 2 || detections[0].Box != image.Rect(10, 20, 30, 60) ||
+		detections[0].Score != 0.9 || detections[1].ClassId != 2 {
+		t.Fatalf("Unexpected detections: %v", detections)
+	}
+	detections[0].Box = image.Rectangle{}
+	if again, _ := d.Detect(nil, "left", 0); again[0].Box != image.Rect(10, 20, 30, 60) {
+		t.Fatalf("Recorded detections were modified by the caller")
+	}
+	if detections, _ := d.Detect(nil, "left", 1); len(detections) != 0 {
+		t.Fatalf("Expected no detections, got %v", detections)
+	}
+	// frames without a camera apply to cameras absent from the file
+	if detections, _ := d.Detect(nil, "right", 0); len(detections) != 1 || detections[0].Box != image.Rect(5, 5, 15, 15) {
+		t.Fatalf("Expected the camera-less frame, got %v", detections)
+	}
+	if _, err := ReadNDJSON(strings.NewReader("{\"frame\":")); !errors.Is(err, ERR_REPLAY_LINE) {
+		t.Fatalf("Expected a malformed line error, got %v", err)
+	}
+}
+
+func TestReadMOT(t *testing.T) {
+	d, err := ReadMOT(strings.NewReader("1,-1,10,20,30,40,0.8,-1,-1,-1\n1,-1,0,0,5,5,0.3,-1,-1,-1\n3,-1,1.5,2.5,10,10,0.9\n"), 7)
+	if err != nil {
+		t.Fatalf("Can't read: %s", err)
+	}
+	detections, _ := d.Detect(nil, "any", 0)
+	if len(detections) != 2 || detections[0].Box != image.Rect(10, 20, 40, 60) ||
+		detections[0].ClassId != 7 || detections[1].Score != float32(0.3) {
+		t.Fatalf("Unexpected detections: %v", detections)
+	}
+	if detections, _ := d.Detect(nil, "any", 2); len(detections) != 1 || detections[0].Box != image.Rect(1, 2, 11, 12) {
+		t.Fatalf("Unexpected detections: %v", detections)
+	}
+	if d.Len() != 2 {
+		t.Fatalf("Expected 2 frames, got %d", d.Len())
+	}
+	for _, line := range []string{"1,-1,10,20", "0,-1,1,1,1,1,1", "1,-1,a,1,1,1,1"} {
+		if _, err := ReadMOT(strings.NewReader(line), 0); !errors.Is(err, ERR_REPLAY_LINE) {
+			t.Fatalf("Expected a malformed line error for %q, got %v", line, err)
+		}
+	}
+}
+
+func TestNewDetectorReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "det.txt")
+	// the second row is a gt.txt ignore flag
+	if err := os.WriteFile(path, []byte("2,-1,0,0,10,10,0.9,-1,-1,-1\n2,-1,50,50,10,10,0,-1,-1,-1\n"), 0o644); err != nil {
+		t.Fatalf("Can't write replay: %s", err)
+	}
+	cfg := &config.ConfigFile{}
+	cfg.Yolo.Detector = config.DetectorTypeReplay
+	cfg.Yolo.ReplayPath = path
+	cfg.Yolo.ReplayFormat = config.ReplayFormatMOT
+	cfg.Yolo.ConfidenceThreshold = 0.5
+	detector, err := NewDetector(cfg)
+	if err != nil {
+		t.Fatalf("Can't create detector: %s", err)
+	}
+	defer detector.Close()
+	if detections, _ := detector.Detect(nil, "0", 1); len(detections) != 1 || detections[0].Box != image.Rect(0, 0, 10, 10) {
+		t.Fatalf("Unexpected detections: %v", detections)
+	}
+
+	// classes other than the tracked ones are dropped too
+	ndjson := filepath.Join(t.TempDir(), "detections.ndjson")
+	if err := os.WriteFile(ndjson, []byte(`{"frame":0,"detections":[{"box":[0,0,10,10],"score":0.9,"class":0},{"box":[0,0,10,10],"score":0.9,"class":2}]}`), 0o644); err != nil {
+		t.Fatalf("Can't write replay: %s", err)
+	}
+	cfg.Yolo.ReplayPath = ndjson
+	cfg.Yolo.ReplayFormat = config.ReplayFormatNDJSON
+	filtered, err := NewDetector(cfg)
+	if err != nil {
+		t.Fatalf("Can't create detector: %s", err)
+	}
+	if detections, _ := filtered.Detect(nil, "0", 0); len(detections) != 1 || detections[0].ClassId != 0 {
+		t.Fatalf("Unexpected detections: %v", detections)
+	}
+
+	cfg.Yolo.ReplayFormat = "csv"
+	if _, err := NewDetector(cfg); !errors.Is(err, ERR_REPLAY_FORMAT) {
+		t.Fatalf("Expected an unknown format error, got %v", err)
+	}
+	cfg.Yolo.Detector = "magic"
+	if _, err := NewDetector(cfg); !errors.Is(err, ERR_DETECTOR) {
+		t.Fatalf("Expected an unknown detector error, got %v", err)
+	}
+}
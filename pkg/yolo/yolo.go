@@ -1,75 +1,177 @@
 package yolo
 
 import (
+	"errors"
+	"fmt"
 	"image"
+	"maps"
+	"slices"
 
 	"github.com/Robogera/detect/pkg/config"
+	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
 	"gocv.io/x/gocv"
 )
 
-func Detect(net *gocv.Net, img *gocv.Mat, cfg *config.ConfigFile, output_layer_names []string, params *gocv.ImageToBlobParams) ([]image.Rectangle, error) {
-	blob := gocv.BlobFromImageWithParams(*img, *params)
+var (
+	ERR_NO_OUTPUTS = errors.New("Model has no output layers")
+	ERR_DETECTOR   = errors.New("Unknown detector")
+)
+
+// Finds objects of the tracked classes in a frame
+type Detector interface {
+	// id is the index of the frame within source
+	Detect(img *gocv.Mat, source string, id uint64) ([]Detection, error)
+	Close() error
+}
+
+// Detector running a yolo-like DNN
+type NetDetector struct {
+	net                gocv.Net
+	backend            gocvcommon.BackendTarget
+	output_layer_names []string
+	params             gocv.ImageToBlobParams
+	decoder            OutputDecoder
+	classes            Classes
+	cfg                *config.ConfigFile
+}
+
+func NewNetDetector(cfg *config.ConfigFile) (*NetDetector, error) {
+	decoder, err := NewDecoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := NewClasses(cfg)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := gocvcommon.ParseBackendTarget(cfg.Yolo.Backend, cfg.Yolo.Target, cfg.Backend.Device)
+	if err != nil {
+		return nil, err
+	}
+	net, err := gocvcommon.ReadNet(cfg.Yolo.Format, cfg.Yolo.Path, cfg.Yolo.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := gocvcommon.SetBackendTarget(&net, backend); err != nil {
+		net.Close()
+		return nil, fmt.Errorf("Can't set backend %s: %w", backend, err)
+	}
+	output_layer_names := gocvcommon.GetOutputLayerNames(&net)
+	if len(output_layer_names) == 0 {
+		net.Close()
+		return nil, fmt.Errorf("%w: %s", ERR_NO_OUTPUTS, cfg.Yolo.Path)
+	}
+	return &NetDetector{
+		net:                net,
+		backend:            backend,
+		output_layer_names: output_layer_names,
+		params: gocv.NewImageToBlobParams(
+			1.0/cfg.Yolo.ScaleFactor,
+			image.Pt(int(cfg.Yolo.W), int(cfg.Yolo.H)),
+			gocv.NewScalar(0, 0, 0, 0),
+			true,
+			gocv.MatTypeCV32F,
+			gocv.DataLayoutNCHW,
+			gocv.PaddingModeLetterbox,
+			gocv.NewScalar(0, 0, 0, 0),
+		),
+		decoder: decoder,
+		classes: classes,
+		cfg:     cfg,
+	}, nil
+}
+
+func (d *NetDetector) Backend() gocvcommon.BackendTarget { return d.backend }
+func (d *NetDetector) OutputLayerNames() []string        { return d.output_layer_names }
+
+func (d *NetDetector) Close() error {
+	return d.net.Close()
+}
+
+func (d *NetDetector) Detect(img *gocv.Mat, source string, id uint64) ([]Detection, error) {
+	blob := gocv.BlobFromImageWithParams(*img, d.params)
 	defer blob.Close()
 
-	net.SetInput(blob, "")
+	d.net.SetInput(blob, "")
 
-	outputs := net.ForwardLayers(output_layer_names)
+	outputs := d.net.ForwardLayers(d.output_layer_names)
 	defer func() {
 		for _, output := range outputs {
 			output.Close()
 		}
 	}()
 
-	// YOLO-models authored by ultralythics are transposed for some reason
-	// this is required to unfuck them
-	// (seems to be in place and zero performance cost so ok)
-	if cfg.Yolo.Transpose {
-		gocv.TransposeND(outputs[0], []int{0, 2, 1}, &outputs[0])
+	candidates, err := d.decoder.Decode(outputs, d.params.Size)
+	if err != nil {
+		return nil, err
 	}
 
-	var nms_boxes []image.Rectangle
-
-	for _, output := range outputs {
-		output_2d := output.Reshape(1, output.Size()[1])
-		cols := output_2d.Cols()
-		var boxes []image.Rectangle
-		var confidences []float32
-		for i := 0; i < output_2d.Rows(); i++ {
-			func() {
-				row := output_2d.RowRange(i, i+1)
-				defer row.Close()
-				// values at indexes 4:cols are the confidence scores of the
-				// object classes
-				confidence_scores_area := row.ColRange(4, cols)
-				defer confidence_scores_area.Close()
-				_, confidence, _, class_id := gocv.MinMaxLoc(confidence_scores_area)
-				// drop everything that isn't most likely a person
-				if class_id.X != int(cfg.Yolo.PersonClassIndex) {
-					return
-				}
-				// elements 0 and 1 correspond to the bounding box center coordinates
-				x, y := int(row.GetFloatAt(0, 0)), int(row.GetFloatAt(0, 1))
-				// and elements 2 and 3 are the box dimensions
-				half_w, half_h := int(row.GetFloatAt(0, 2)/2.0), int(row.GetFloatAt(0, 3)/2.0)
-				boxes = append(boxes, image.Rect(x-half_w, y-half_h, x+half_w, y+half_h))
-				confidences = append(confidences, confidence)
-			}()
-		}
-		output_2d.Close()
+	// the low confidence tier goes through nms together with the
+	// high one so that it doesn't duplicate the high boxes
+	threshold := candidateThreshold(d.cfg)
 
-		if len(boxes) > 0 {
-			indices := gocv.NMSBoxes(boxes, confidences, cfg.Yolo.ConfidenceThreshold, cfg.Yolo.NMSThreshold)
+	// grouped by class so that overlapping objects of different
+	// classes (a person on a bicycle) don't suppress each other
+	detections := make(map[int][]Detection)
+	for _, candidate := range candidates {
+		if !d.classes.Contains(candidate.ClassId) ||
+			candidate.Score < threshold {
+			continue
+		}
+		detections[candidate.ClassId] = append(detections[candidate.ClassId], candidate)
+	}
 
-			nms_boxes = make([]image.Rectangle, len(indices))
-			for i, j := range indices {
-				nms_boxes[i] = boxes[j]
-			}
-			if len(nms_boxes) > 0 {
+	var nms_detections []Detection
+	// in class order so that the detections come out in the same
+	// order every run
+	for _, class_id := range slices.Sorted(maps.Keys(detections)) {
+		class_detections := detections[class_id]
+		if d.decoder.HasNMS() {
+			nms_detections = append(nms_detections, class_detections...)
+			continue
+		}
+		boxes := make([]image.Rectangle, len(class_detections))
+		confidences := make([]float32, len(class_detections))
+		for i, detection := range class_detections {
+			boxes[i] = detection.Box
+			confidences[i] = detection.Score
+		}
+		for _, i := range gocv.NMSBoxes(boxes, confidences, threshold, d.cfg.Yolo.NMSThreshold) {
+			nms_detections = append(nms_detections, class_detections[i])
+		}
+	}
 
-				nms_boxes = params.BlobRectsToImageRects(nms_boxes, image.Pt(img.Cols(), img.Rows()))
-			}
+	if len(nms_detections) > 0 {
+		boxes := make([]image.Rectangle, len(nms_detections))
+		for i, detection := range nms_detections {
+			boxes[i] = detection.Box
+		}
+		boxes = d.params.BlobRectsToImageRects(boxes, image.Pt(img.Cols(), img.Rows()))
+		for i := range nms_detections {
+			nms_detections[i].Box = boxes[i]
 		}
 	}
 
-	return nms_boxes, nil
+	return nms_detections, nil
+}
+
+// Lowest score a detection of either confidence tier can have
+func candidateThreshold(cfg *config.ConfigFile) float32 {
+	threshold := cfg.Yolo.ConfidenceThreshold
+	if low := cfg.Yolo.LowConfidenceThreshold; low > 0 && low < threshold {
+		threshold = low
+	}
+	return threshold
+}
+
+// Detector selected by the config
+func NewDetector(cfg *config.ConfigFile) (Detector, error) {
+	switch config.DetectorType(cfg.Yolo.Detector) {
+	case config.DetectorTypeDNN, "":
+		return NewNetDetector(cfg)
+	case config.DetectorTypeReplay:
+		return NewReplayDetector(cfg)
+	default:
+		return nil, fmt.Errorf("%w: %s", ERR_DETECTOR, cfg.Yolo.Detector)
+	}
 }
@@ -0,0 +1,338 @@
+package zones
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"time"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+var (
+	ERR_ZONE = errors.New("Bad zone")
+	ERR_LINE = errors.New("Bad line")
+)
+
+type EventType string
+
+const (
+	EVENT_ENTER EventType = "enter"
+	EVENT_EXIT  EventType = "exit"
+	EVENT_CROSS EventType = "cross"
+	// stayed in a zone longer than its loitering threshold
+	EVENT_LOITER EventType = "loiter"
+)
+
+// Side a line is crossed from. Side a is on the left looking from
+// the start of the line to its end, side b on the right
+type Direction string
+
+const (
+	DIRECTION_A_TO_B Direction = "a_to_b"
+	DIRECTION_B_TO_A Direction = "b_to_a"
+)
+
+type Zone struct {
+	Name    string
+	Polygon []image.Point
+	// 0 for no loitering alerts
+	Loiter time.Duration
+}
+
+// Even-odd rule, points on the left and top edges are inside
+func (z Zone) Contains(p image.Point) bool {
+	inside := false
+	for i, j := 0, len(z.Polygon)-1; i < len(z.Polygon); j, i = i, i+1 {
+		a, b := z.Polygon[i], z.Polygon[j]
+		if (a.Y > p.Y) == (b.Y > p.Y) {
+			continue
+		}
+		// x of the edge at p.Y
+		x := float64(a.X) + float64(p.Y-a.Y)*float64(b.X-a.X)/float64(b.Y-a.Y)
+		if float64(p.X) < x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+type Line struct {
+	Name       string
+	Start, End image.Point
+}
+
+// Negative on side a, positive on side b, 0 on the line. The image
+// y axis points down
+func (l Line) side(p image.Point) int {
+	d, v := l.End.Sub(l.Start), p.Sub(l.Start)
+	return d.X*v.Y - d.Y*v.X
+}
+
+// Direction of the move from one point to another if it crosses the
+// segment. Touching the line doesn't count until the other side is
+// reached
+func (l Line) crossing(from, to image.Point) (Direction, bool) {
+	side_from, side_to := l.side(from), l.side(to)
+	if side_from == 0 || side_to == 0 || (side_from < 0) == (side_to < 0) {
+		return "", false
+	}
+	// the segment's ends have to be on the different sides of the move
+	move := Line{Start: from, End: to}
+	side_start, side_end := move.side(l.Start), move.side(l.End)
+	if side_start != 0 && side_end != 0 && (side_start < 0) == (side_end < 0) {
+		return "", false
+	}
+	if side_from < 0 {
+		return DIRECTION_A_TO_B, true
+	}
+	return DIRECTION_B_TO_A, true
+}
+
+type Event struct {
+	Type EventType
+	// of the zone or the line
+	Name string
+	Id   string
+	Time time.Time
+	// only for EVENT_CROSS
+	Direction Direction
+	// time in the zone for EVENT_EXIT and EVENT_LOITER
+	Dwell time.Duration
+}
+
+type ZoneCount struct {
+	Name      string `json:"name"`
+	Occupancy int    `json:"occupancy"`
+	In        uint   `json:"in"`
+	Out       uint   `json:"out"`
+}
+
+type LineCount struct {
+	Name string `json:"name"`
+	AToB uint   `json:"a_to_b"`
+	BToA uint   `json:"b_to_a"`
+}
+
+// Stay of a person in a zone
+type visit struct {
+	entered time.Time
+	alerted bool
+}
+
+// Follows people through the zones and across the lines
+type Counter struct {
+	zones []Zone
+	lines []Line
+	// people in every zone by id
+	inside     []map[string]*visit
+	zone_count []ZoneCount
+	line_count []LineCount
+	// positions at the previous update
+	last map[string]image.Point
+	// last positions off every line, a point right on a line is
+	// skipped so a crossing through it still counts
+	beside []map[string]image.Point
+}
+
+func NewCounter(zones []Zone, lines []Line) *Counter {
+	c := &Counter{
+		zones:      zones,
+		lines:      lines,
+		inside:     make([]map[string]*visit, len(zones)),
+		zone_count: make([]ZoneCount, len(zones)),
+		line_count: make([]LineCount, len(lines)),
+		last:       make(map[string]image.Point),
+		beside:     make([]map[string]image.Point, len(lines)),
+	}
+	for i, zone := range zones {
+		c.inside[i] = make(map[string]*visit)
+		c.zone_count[i].Name = zone.Name
+	}
+	for i, line := range lines {
+		c.beside[i] = make(map[string]image.Point)
+		c.line_count[i].Name = line.Name
+	}
+	return c
+}
+
+// Counter of the zones and lines of a camera. Every zone needs three
+// points at least and names have to be unique among the zones and
+// among the lines
+func FromConfig(zone_cfgs []config.ZoneConfig, line_cfgs []config.LineConfig) (*Counter, error) {
+	zones := make([]Zone, 0, len(zone_cfgs))
+	names := make(map[string]bool, len(zone_cfgs))
+	for i, zone_cfg := range zone_cfgs {
+		name := zone_cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("zone%d", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%w: duplicate name %s", ERR_ZONE, name)
+		}
+		names[name] = true
+		if len(zone_cfg.Points) < 3 {
+			return nil, fmt.Errorf("%w: %s has %d points, need 3", ERR_ZONE, name, len(zone_cfg.Points))
+		}
+		polygon := make([]image.Point, 0, len(zone_cfg.Points))
+		for _, point := range zone_cfg.Points {
+			polygon = append(polygon, image.Pt(int(point.X), int(point.Y)))
+		}
+		if zone_cfg.LoiterSec < 0 {
+			return nil, fmt.Errorf("%w: %s has negative loiter_sec", ERR_ZONE, name)
+		}
+		zones = append(zones, Zone{
+			Name:    name,
+			Polygon: polygon,
+			Loiter:  time.Duration(zone_cfg.LoiterSec * float64(time.Second)),
+		})
+	}
+	lines := make([]Line, 0, len(line_cfgs))
+	names = make(map[string]bool, len(line_cfgs))
+	for i, line_cfg := range line_cfgs {
+		name := line_cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("line%d", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%w: duplicate name %s", ERR_LINE, name)
+		}
+		names[name] = true
+		line := Line{
+			Name:  name,
+			Start: image.Pt(int(line_cfg.Start.X), int(line_cfg.Start.Y)),
+			End:   image.Pt(int(line_cfg.End.X), int(line_cfg.End.Y)),
+		}
+		if line.Start == line.End {
+			return nil, fmt.Errorf("%w: %s has no length", ERR_LINE, name)
+		}
+		lines = append(lines, line)
+	}
+	return NewCounter(zones, lines), nil
+}
+
+// Moves the people to positions and returns the events it caused.
+// People missing from positions leave every zone they were in. A
+// loitering alert is raised once per stay
+func (c *Counter) Update(t time.Time, positions map[string]image.Point) []Event {
+	events := make([]Event, 0)
+	ids := make([]string, 0, len(positions))
+	for id := range positions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		position := positions[id]
+		for i, zone := range c.zones {
+			stay, was_inside := c.inside[i][id]
+			switch inside := zone.Contains(position); {
+			case inside && !was_inside:
+				c.inside[i][id] = &visit{entered: t}
+				c.zone_count[i].In++
+				events = append(events, Event{Type: EVENT_ENTER, Name: zone.Name, Id: id, Time: t})
+			case !inside && was_inside:
+				delete(c.inside[i], id)
+				c.zone_count[i].Out++
+				events = append(events, Event{Type: EVENT_EXIT, Name: zone.Name, Id: id, Time: t, Dwell: t.Sub(stay.entered)})
+			case inside && zone.Loiter > 0 && !stay.alerted && t.Sub(stay.entered) >= zone.Loiter:
+				stay.alerted = true
+				events = append(events, Event{Type: EVENT_LOITER, Name: zone.Name, Id: id, Time: t, Dwell: t.Sub(stay.entered)})
+			}
+		}
+		for i, line := range c.lines {
+			if line.side(position) == 0 {
+				continue
+			}
+			last, ok := c.beside[i][id]
+			c.beside[i][id] = position
+			if !ok {
+				continue
+			}
+			direction, crossed := line.crossing(last, position)
+			if !crossed {
+				continue
+			}
+			if direction == DIRECTION_A_TO_B {
+				c.line_count[i].AToB++
+			} else {
+				c.line_count[i].BToA++
+			}
+			events = append(events, Event{Type: EVENT_CROSS, Name: line.Name, Id: id, Time: t, Direction: direction})
+		}
+		c.last[id] = position
+	}
+
+	gone := make([]string, 0)
+	for id := range c.last {
+		if _, ok := positions[id]; !ok {
+			gone = append(gone, id)
+		}
+	}
+	slices.Sort(gone)
+	for _, id := range gone {
+		for i, zone := range c.zones {
+			if stay, ok := c.inside[i][id]; ok {
+				delete(c.inside[i], id)
+				c.zone_count[i].Out++
+				events = append(events, Event{Type: EVENT_EXIT, Name: zone.Name, Id: id, Time: t, Dwell: t.Sub(stay.entered)})
+			}
+		}
+		for i := range c.lines {
+			delete(c.beside[i], id)
+		}
+		delete(c.last, id)
+	}
+
+	for i := range c.zones {
+		c.zone_count[i].Occupancy = len(c.inside[i])
+	}
+	return events
+}
+
+// Time the person has spent in every zone they are in by the zone's
+// name
+func (c *Counter) Dwell(id string, t time.Time) map[string]time.Duration {
+	dwell := make(map[string]time.Duration)
+	for i, zone := range c.zones {
+		if stay, ok := c.inside[i][id]; ok {
+			dwell[zone.Name] = t.Sub(stay.entered)
+		}
+	}
+	return dwell
+}
+
+func (c *Counter) Zones() []Zone { return c.zones }
+func (c *Counter) Lines() []Line { return c.lines }
+
+// Copies of the counts in the configured order
+func (c *Counter) ZoneCounts() []ZoneCount { return slices.Clone(c.zone_count) }
+func (c *Counter) LineCounts() []LineCount { return slices.Clone(c.line_count) }
+
+type ExportedEvent struct {
+	Event     string    `json:"event"`
+	Id        string    `json:"id"`
+	Zone      string    `json:"zone,omitempty"`
+	Line      string    `json:"line,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	Time      time.Time `json:"time"`
+	DwellSec  float64   `json:"dwell_sec,omitempty"`
+	// JPEG of the person for EVENT_LOITER, base64 in json
+	Snapshot []byte `json:"snapshot,omitempty"`
+}
+
+func (e Event) Export() *ExportedEvent {
+	exported := &ExportedEvent{
+		Event:     string(e.Type),
+		Id:        e.Id,
+		Direction: string(e.Direction),
+		Time:      e.Time,
+		DwellSec:  e.Dwell.Seconds(),
+	}
+	if e.Type == EVENT_CROSS {
+		exported.Line = e.Name
+	} else {
+		exported.Zone = e.Name
+	}
+	return exported
+}
@@ -0,0 +1,214 @@
+package zones
+
+import (
+	"errors"
+	"image"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Robogera/detect/pkg/config"
+)
+
+func TestContains(t *testing.T) {
+	square := Zone{Polygon: []image.Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}}
+	// U shape open to the top
+	u := Zone{Polygon: []image.Point{{0, 0}, {30, 0}, {30, 70}, {70, 70}, {70, 0}, {100, 0}, {100, 100}, {0, 100}}}
+	cases := []struct {
+		zone     Zone
+		p        image.Point
+		expected bool
+	}{
+		{square, image.Pt(50, 50), true},
+		{square, image.Pt(0, 50), true},
+		{square, image.Pt(150, 50), false},
+		{square, image.Pt(50, -1), false},
+		{square, image.Pt(-50, 50), false},
+		{u, image.Pt(10, 10), true},
+		{u, image.Pt(50, 90), true},
+		{u, image.Pt(50, 30), false},
+		{u, image.Pt(90, 10), true},
+	}
+	for _, c := range cases {
+		if inside := c.zone.Contains(c.p); inside != c.expected {
+			t.Fatalf("%v in %v: expected %t", c.p, c.zone.Polygon, c.expected)
+		}
+	}
+}
+
+func TestCrossing(t *testing.T) {
+	// pointing right, side a is above
+	line := Line{Start: image.Pt(0, 100), End: image.Pt(200, 100)}
+	cases := []struct {
+		from, to  image.Point
+		direction Direction
+		crossed   bool
+	}{
+		{image.Pt(50, 50), image.Pt(50, 150), DIRECTION_A_TO_B, true},
+		{image.Pt(50, 150), image.Pt(60, 50), DIRECTION_B_TO_A, true},
+		{image.Pt(50, 50), image.Pt(150, 90), "", false},
+		// past the end of the segment
+		{image.Pt(250, 50), image.Pt(250, 150), "", false},
+		{image.Pt(-10, 50), image.Pt(-10, 150), "", false},
+		// diagonally through the end
+		{image.Pt(190, 50), image.Pt(210, 150), DIRECTION_A_TO_B, true},
+		// onto the line and not over it yet
+		{image.Pt(50, 50), image.Pt(50, 100), "", false},
+		{image.Pt(50, 100), image.Pt(50, 150), "", false},
+	}
+	for _, c := range cases {
+		if direction, crossed := line.crossing(c.from, c.to); direction != c.direction || crossed != c.crossed {
+			t.Fatalf("%v -> %v: expected %q, %t, got %q, %t", c.from, c.to, c.direction, c.crossed, direction, crossed)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter(
+		[]Zone{{Name: "left", Polygon: []image.Point{{0, 0}, {100, 0}, {100, 200}, {0, 200}}}},
+		[]Line{{Name: "door", Start: image.Pt(150, 0), End: image.Pt(150, 200)}},
+	)
+	start := time.Unix(0, 0)
+	type frame struct {
+		positions map[string]image.Point
+		events    []Event
+	}
+	// the line points down so side a is on the right
+	frames := []frame{
+		{map[string]image.Point{"a": {50, 100}, "b": {200, 100}}, []Event{
+			{Type: EVENT_ENTER, Name: "left", Id: "a"},
+		}},
+		{map[string]image.Point{"a": {120, 100}, "b": {120, 100}}, []Event{
+			{Type: EVENT_EXIT, Name: "left", Id: "a", Dwell: time.Second},
+			{Type: EVENT_CROSS, Name: "door", Id: "b", Direction: DIRECTION_A_TO_B},
+		}},
+		{map[string]image.Point{"a": {180, 100}, "b": {90, 100}}, []Event{
+			{Type: EVENT_CROSS, Name: "door", Id: "a", Direction: DIRECTION_B_TO_A},
+			{Type: EVENT_ENTER, Name: "left", Id: "b"},
+		}},
+		// b is gone while inside
+		{map[string]image.Point{"a": {180, 110}}, []Event{
+			{Type: EVENT_EXIT, Name: "left", Id: "b", Dwell: time.Second},
+		}},
+		{map[string]image.Point{"a": {180, 120}, "c": {10, 10}}, []Event{
+			{Type: EVENT_ENTER, Name: "left", Id: "c"},
+		}},
+	}
+	for i, f := range frames {
+		now := start.Add(time.Duration(i) * time.Second)
+		for j := range f.events {
+			f.events[j].Time = now
+		}
+		if events := counter.Update(now, f.positions); !slices.Equal(events, f.events) {
+			t.Fatalf("Frame %d: expected %+v, got %+v", i, f.events, events)
+		}
+	}
+	if zones := counter.ZoneCounts(); !slices.Equal(zones, []ZoneCount{{Name: "left", Occupancy: 1, In: 3, Out: 2}}) {
+		t.Fatalf("Unexpected zone counts %+v", zones)
+	}
+	if lines := counter.LineCounts(); !slices.Equal(lines, []LineCount{{Name: "door", AToB: 1, BToA: 1}}) {
+		t.Fatalf("Unexpected line counts %+v", lines)
+	}
+}
+
+// Integer foot points land right on horizontal and vertical lines
+func TestCrossingThroughTheLine(t *testing.T) {
+	// pointing right, side a is above
+	counter := NewCounter(nil, []Line{{Name: "door", Start: image.Pt(0, 300), End: image.Pt(640, 300)}})
+	start := time.Unix(0, 0)
+	walks := map[string][]int{
+		"down": {294, 297, 300, 303, 306},
+		"up":   {306, 303, 300, 300, 297, 294},
+		// touches the line and turns back
+		"back": {294, 297, 300, 297, 294},
+	}
+	for i := range 6 {
+		positions := make(map[string]image.Point)
+		for id, walk := range walks {
+			if i < len(walk) {
+				positions[id] = image.Pt(320, walk[i])
+			}
+		}
+		counter.Update(start.Add(time.Duration(i)*time.Second), positions)
+	}
+	if lines := counter.LineCounts(); !slices.Equal(lines, []LineCount{{Name: "door", AToB: 1, BToA: 1}}) {
+		t.Fatalf("Unexpected line counts %+v", lines)
+	}
+}
+
+func TestLoiter(t *testing.T) {
+	counter := NewCounter(
+		[]Zone{
+			{Name: "watched", Polygon: []image.Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}, Loiter: 2 * time.Second},
+			{Name: "free", Polygon: []image.Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}},
+		},
+		nil,
+	)
+	start := time.Unix(0, 0)
+	inside := map[string]image.Point{"a": {50, 50}}
+	cases := []struct {
+		positions map[string]image.Point
+		loiter    bool
+		dwell     time.Duration
+	}{
+		{inside, false, 0},
+		{inside, false, time.Second},
+		{inside, true, 2 * time.Second},
+		// once per stay
+		{inside, false, 3 * time.Second},
+		{map[string]image.Point{"a": {150, 50}}, false, 0},
+		{inside, false, 0},
+		{inside, false, time.Second},
+		{inside, true, 2 * time.Second},
+	}
+	for i, c := range cases {
+		now := start.Add(time.Duration(i) * time.Second)
+		loiter := false
+		for _, event := range counter.Update(now, c.positions) {
+			if event.Type != EVENT_LOITER {
+				continue
+			}
+			if event.Name != "watched" || event.Id != "a" || event.Dwell != c.dwell {
+				t.Fatalf("Frame %d: unexpected alert %+v", i, event)
+			}
+			loiter = true
+		}
+		if loiter != c.loiter {
+			t.Fatalf("Frame %d: expected alert %t, got %t", i, c.loiter, loiter)
+		}
+		if dwell := counter.Dwell("a", now); dwell["watched"] != c.dwell || dwell["free"] != c.dwell {
+			t.Fatalf("Frame %d: expected dwell %s, got %v", i, c.dwell, dwell)
+		}
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	square := config.Contour{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}}
+	cases := []struct {
+		name  string
+		zones []config.ZoneConfig
+		lines []config.LineConfig
+		err   error
+	}{
+		{"empty", nil, nil, nil},
+		{"default names", []config.ZoneConfig{{Points: square}, {Points: square}}, []config.LineConfig{{End: config.Point{X: 1}}}, nil},
+		{"too few points", []config.ZoneConfig{{Points: square[:2]}}, nil, ERR_ZONE},
+		{"duplicate zone", []config.ZoneConfig{{Name: "a", Points: square}, {Name: "a", Points: square}}, nil, ERR_ZONE},
+		{"point line", nil, []config.LineConfig{{Start: config.Point{X: 1}, End: config.Point{X: 1}}}, ERR_LINE},
+		{"negative loiter", []config.ZoneConfig{{Points: square, LoiterSec: -1}}, nil, ERR_ZONE},
+		{"duplicate line", nil, []config.LineConfig{{Name: "a", End: config.Point{X: 1}}, {Name: "a", End: config.Point{Y: 1}}}, ERR_LINE},
+	}
+	for _, c := range cases {
+		counter, err := FromConfig(c.zones, c.lines)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if err == nil && (len(counter.Zones()) != len(c.zones) || len(counter.Lines()) != len(c.lines)) {
+			t.Fatalf("%s: expected %d zones and %d lines", c.name, len(c.zones), len(c.lines))
+		}
+	}
+	counter, _ := FromConfig([]config.ZoneConfig{{Points: square}}, []config.LineConfig{{End: config.Point{X: 1}}})
+	if counter.Zones()[0].Name != "zone0" || counter.Lines()[0].Name != "line0" {
+		t.Fatalf("Unexpected default names %s and %s", counter.Zones()[0].Name, counter.Lines()[0].Name)
+	}
+}
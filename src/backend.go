@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/Robogera/detect/pkg/config"
+	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
+)
+
+const (
+	probe_model_yolo = "yolo"
+	probe_model_reid = "reid"
+
+	probe_timeout = 2 * time.Minute
+)
+
+// Settings of a model that are relevant for the backend selection
+type modelBackend struct {
+	name        string
+	format      string
+	path        string
+	config_path string
+	backend     *string
+	target      *string
+	size        image.Point
+}
+
+func modelBackends(cfg *config.ConfigFile) map[string]modelBackend {
+	return map[string]modelBackend{
+		probe_model_yolo: {
+			name: probe_model_yolo, format: cfg.Yolo.Format,
+			path: cfg.Yolo.Path, config_path: cfg.Yolo.ConfigPath,
+			backend: &cfg.Yolo.Backend, target: &cfg.Yolo.Target,
+			size: image.Pt(int(cfg.Yolo.W), int(cfg.Yolo.H)),
+		},
+		probe_model_reid: {
+			name: probe_model_reid, format: cfg.Reid.Format,
+			path: cfg.Reid.Path, config_path: cfg.Reid.ConfigPath,
+			backend: &cfg.Reid.Backend, target: &cfg.Reid.Target,
+			size: image.Pt(int(cfg.Reid.W), int(cfg.Reid.H)),
+		},
+	}
+}
+
+// Finds the first backend of the fallback chain that actually
+// works for every model and writes it back to the config.
+// OpenCV aborts on unsupported backends instead of returning
+// an error so every candidate is tried in a child process first
+func selectBackends(ctx context.Context, parent_logger *slog.Logger, cfg *config.ConfigFile) error {
+	logger := parent_logger.With("coroutine", "backend")
+
+	exe_path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("Can't find executable: %w", err)
+	}
+
+	for _, model := range modelBackends(cfg) {
+		if model.name == probe_model_yolo && config.DetectorType(cfg.Yolo.Detector) == config.DetectorTypeReplay {
+			continue
+		}
+		if model.name == probe_model_reid && !cfg.Reid.Enabled {
+			continue
+		}
+		preferred, err := gocvcommon.ParseBackendTarget(*model.backend, *model.target, cfg.Backend.Device)
+		if err != nil {
+			logger.Error("Bad backend config", "model", model.name, "error", err)
+			return ERR_INVALID_CONFIG
+		}
+		selected := false
+		for _, candidate := range gocvcommon.FallbackChain(preferred) {
+			probe_ctx, cancel := context.WithTimeout(ctx, probe_timeout)
+			output, err := exec.CommandContext(probe_ctx, exe_path,
+				"-config", cfg_path,
+				"-probe", model.name,
+				"-probe-backend", candidate.Backend.String(),
+				"-probe-target", candidate.Target.String(),
+			).CombinedOutput()
+			cancel()
+			if err != nil {
+				logger.Warn("Backend unavailable, falling back",
+					"model", model.name, "backend", candidate, "error", err, "output", string(output))
+				continue
+			}
+			*model.backend, *model.target = candidate.Backend.String(), candidate.Target.String()
+			logger.Info("Backend selected", "model", model.name, "backend", candidate)
+			selected = true
+			break
+		}
+		if !selected {
+			logger.Error("No usable backend", "model", model.name, "path", model.path)
+			return ERR_CANT_SET_BACKEND
+		}
+	}
+	return nil
+}
+
+// Entry point of the child process started by selectBackends.
+// Returns the exit code
+func probe(cfg *config.ConfigFile, model_name, backend, target string) int {
+	model, ok := modelBackends(cfg)[model_name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown model %s\n", model_name)
+		return 2
+	}
+	bt, err := gocvcommon.ParseBackendTarget(backend, target, cfg.Backend.Device)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	net, err := gocvcommon.ReadNet(model.format, model.path, model.config_path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer net.Close()
+	if err := gocvcommon.SetBackendTarget(&net, bt); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	gocvcommon.Probe(&net, model.size)
+	return 0
+}
@@ -21,26 +21,26 @@ import (
 func debug_streamreader(
 	ctx context.Context,
 	logger *slog.Logger,
-	cfg *config.ConfigFile,
+	camera config.CameraConfig,
 	mat_chan chan<- indexed.Indexed[gocv.Mat],
 ) error {
 
-	dir, err := os.Open(cfg.Input.Path)
+	dir, err := os.Open(camera.Input.Path)
 	if err != nil {
-		logger.Error("Can't open debug folder %s", "error", cfg.Input.Path)
+		logger.Error("Can't open debug folder %s", "error", camera.Input.Path)
 		return err
 	}
 
 	files, err := dir.Readdir(-1)
 	if err != nil {
-		logger.Error("Can't read debug folder %s", "error", cfg.Input.Path)
+		logger.Error("Can't read debug folder %s", "error", camera.Input.Path)
 		return err
 	}
 
 	names := make([]string, 0)
 	for _, f := range files {
 		if strings.Contains(f.Name(), "debug.jpg") {
-			names = append(names, filepath.Join(cfg.Input.Path, f.Name()))
+			names = append(names, filepath.Join(camera.Input.Path, f.Name()))
 		}
 	}
 
@@ -65,7 +65,7 @@ func debug_streamreader(
 			}
 
 			if img.Empty() {
-				logger.Error("Empty frame received, skipping", "stream", cfg.Input.Path)
+				logger.Error("Empty frame received, skipping", "stream", camera.Input.Path)
 				img.Close()
 				continue
 			}
@@ -74,7 +74,7 @@ func debug_streamreader(
 			case <-ctx.Done():
 				logger.Info("Streamreader cancelled by context")
 				return context.Canceled
-			case mat_chan <- indexed.NewIndexed(frame_id, time.Now(), img):
+			case mat_chan <- indexed.NewIndexed(camera.Id, frame_id, time.Now(), img):
 				frame_id++
 			}
 		}
@@ -2,20 +2,19 @@ package main
 
 import (
 	"context"
-	"image"
 	"log/slog"
 	"runtime"
 
 	"github.com/Robogera/detect/pkg/config"
-	"github.com/Robogera/detect/pkg/yolo"
-	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
 	"github.com/Robogera/detect/pkg/indexed"
+	"github.com/Robogera/detect/pkg/onnx"
+	"github.com/Robogera/detect/pkg/yolo"
 	"gocv.io/x/gocv"
 )
 
 type ProcessedFrame struct {
-	Mat   *gocv.Mat
-	Boxes []image.Rectangle
+	Mat        *gocv.Mat
+	Detections []yolo.Detection
 }
 
 func detector(
@@ -23,7 +22,8 @@ func detector(
 	parent_logger *slog.Logger,
 	cfg *config.ConfigFile,
 	in_chan <-chan indexed.Indexed[*gocv.Mat],
-	out_chan chan<- indexed.Indexed[ProcessedFrame],
+	// sorter input of every camera keyed by camera id
+	out_chans map[string]chan<- indexed.Indexed[ProcessedFrame],
 ) error {
 
 	// not sure if this helps
@@ -31,72 +31,44 @@ func detector(
 
 	logger := parent_logger.With("coroutine", "detector")
 
-	var net gocv.Net
-	defer net.Close()
-
-	// TODO: panic and recover when the CGO segfaults maybe?
-	switch config.ModelFormat(cfg.Yolo.Format) {
-	case config.ModelFormatCaffe:
-		// TODO: test
-		net = gocv.ReadNetFromCaffe(cfg.Yolo.ConfigPath, cfg.Yolo.Path)
-	case config.ModelFormatONNX:
-		net = gocv.ReadNetFromONNX(cfg.Yolo.Path)
-	case config.ModelFormatOpenVINO:
-		// TODO: test
-		net = gocv.ReadNet(cfg.Yolo.Path, cfg.Yolo.ConfigPath)
-	}
-
-	err := net.SetPreferableBackend(gocv.NetBackendOpenVINO)
-	if err != nil {
-		logger.Error("Can't set openvino backend", "model", cfg.Yolo.Path)
-		return ERR_BAD_MODEL
-	}
-	err = net.SetPreferableTarget(gocv.NetTargetCPU)
+	detector, err := yolo.NewDetector(cfg)
 	if err != nil {
-		logger.Error("Can't set cpu backend", "model", cfg.Yolo.Path)
+		logger.Error("Can't init detector", "detector", cfg.Yolo.Detector, "model", cfg.Yolo.Path, "error", err)
 		return ERR_BAD_MODEL
 	}
+	defer detector.Close()
 
-	if net.Empty() {
-		logger.Error("Error reading network model")
-		return ERR_BAD_MODEL
+	switch d := detector.(type) {
+	case *yolo.NetDetector:
+		logger.Info("Model loaded", "model", cfg.Yolo.Path, "backend", d.Backend())
+		logger.Debug("Model info", "model", cfg.Yolo.Path, "output layers", d.OutputLayerNames())
+	case *yolo.ReplayDetector:
+		logger.Info("Replaying detections", "path", cfg.Yolo.ReplayPath, "frames", d.Len())
 	}
-	defer net.Close()
 
-	output_layer_names := gocvcommon.GetOutputLayerNames(&net)
-	if len(output_layer_names) == 0 {
-		logger.Error("Can't read output layer name", "model", cfg.Yolo.Path)
-		return ERR_BAD_MODEL
-	}
-	logger.Debug("Model info", "model", cfg.Yolo.Path, "output layers", output_layer_names)
-
-	blob_conv_params := gocv.NewImageToBlobParams(
-		1.0/cfg.Yolo.ScaleFactor,
-		image.Pt(int(cfg.Yolo.W), int(cfg.Yolo.H)),
-		gocv.NewScalar(0, 0, 0, 0),
-		true,
-		gocv.MatTypeCV32F,
-		gocv.DataLayoutNCHW,
-		gocv.PaddingModeLetterbox,
-		gocv.NewScalar(0, 0, 0, 0),
-	)
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Cancelled by context")
 			return context.Canceled
 		case frame := <-in_chan:
-			boxes, err := yolo.Detect(&net, frame.Value(), cfg, output_layer_names, &blob_conv_params)
-      if err != nil {
-				logger.Error("Detection failure", "error", err)
-      }
+			out_chan, ok := out_chans[frame.Source()]
+			if !ok {
+				logger.Error("Frame from unknown camera", "camera", frame.Source())
+				frame.Value().Close()
+				continue
+			}
+			detections, err := detector.Detect(frame.Value(), frame.Source(), frame.Id())
+			if err != nil {
+				logger.Error("Detection failure", "camera", frame.Source(), "error", err)
+			}
 			select {
-			case out_chan <- indexed.NewIndexed(frame.Id(), frame.Time(), ProcessedFrame{
-				Mat: frame.Value(),
-				Boxes: boxes,
+			case out_chan <- indexed.NewIndexed(frame.Source(), frame.Id(), frame.Time(), ProcessedFrame{
+				Mat:        frame.Value(),
+				Detections: detections,
 			}):
-				if len(boxes) > 0 {
-					logger.Info("Detected", "boxes", boxes)
+				if len(detections) > 0 {
+					logger.Info("Detected", "camera", frame.Source(), "detections", detections)
 				}
 			case <-ctx.Done():
 				logger.Info("Cancelled by context")
@@ -105,3 +77,107 @@ func detector(
 		}
 	}
 }
+
+// Fills the yolo settings left empty in the config from the onnx
+// model itself. Has to run before the detectors start
+func autoconfigure(parent_logger *slog.Logger, cfg *config.ConfigFile) {
+	logger := parent_logger.With("coroutine", "detector")
+
+	if config.DetectorType(cfg.Yolo.Detector) == config.DetectorTypeReplay ||
+		config.ModelFormat(cfg.Yolo.Format) != config.ModelFormatONNX {
+		return
+	}
+	model, err := onnx.Read(cfg.Yolo.Path)
+	if err != nil {
+		logger.Warn("Can't read model metadata", "model", cfg.Yolo.Path, "error", err)
+		return
+	}
+
+	var inferred []any
+
+	if cfg.Yolo.W == 0 || cfg.Yolo.H == 0 {
+		w, h, err := model.InputSize()
+		if err != nil {
+			logger.Warn("Can't infer input size", "model", cfg.Yolo.Path, "error", err)
+		} else {
+			cfg.Yolo.W, cfg.Yolo.H = uint(w), uint(h)
+			inferred = append(inferred, "w", w, "h", h)
+		}
+	}
+
+	names, names_err := model.Names()
+	if names_err == nil {
+		if len(cfg.Yolo.Labels) == 0 && cfg.Yolo.LabelsPath == "" {
+			cfg.Yolo.Labels = names
+			inferred = append(inferred, "labels", names)
+		}
+		if cfg.Yolo.PersonClassIndex == 0 && len(cfg.Yolo.Classes) == 0 {
+			for class_id, name := range names {
+				if name == "person" {
+					cfg.Yolo.PersonClassIndex = uint(class_id)
+					inferred = append(inferred, "person_class_index", class_id)
+					break
+				}
+			}
+		}
+	}
+
+	if cfg.Yolo.Decoder == "" && len(model.Outputs) > 0 {
+		shape := model.Outputs[0].Shape
+		classes := len(cfg.Yolo.Labels)
+		if names_err == nil {
+			classes = len(names)
+		}
+		switch decoder, ok := decoderFromShape(shape, classes); {
+		case len(shape) != 3:
+		case ok:
+			cfg.Yolo.Decoder = decoder
+			inferred = append(inferred, "decoder", cfg.Yolo.Decoder)
+		case classes > 0:
+			logger.Warn("Can't tell the decoder from the output shape, set it in the config",
+				"model", cfg.Yolo.Path, "shape", shape, "classes", classes)
+		case !cfg.Yolo.Transpose && shape[1] > 0 && shape[2] > 0 && shape[1] < shape[2]:
+			// fewer rows than columns means the candidates are the columns
+			cfg.Yolo.Transpose = true
+			inferred = append(inferred, "transpose", true)
+		}
+	}
+
+	if task := model.Task(); task != "" && task != "detect" {
+		logger.Warn("Model isn't a detection model", "model", cfg.Yolo.Path, "task", task)
+	}
+	if stride, err := model.Stride(); err == nil {
+		logger.Debug("Model stride", "model", cfg.Yolo.Path, "stride", stride)
+	}
+
+	if len(inferred) > 0 {
+		logger.Info("Config inferred from model", append([]any{"model", cfg.Yolo.Path}, inferred...)...)
+	}
+}
+
+// Decoder of a [1, rows, columns] output of a model with the given
+// amount of classes. False if the shape fits none of them or several,
+// e.g. a single class end-to-end model looks like a single class v5
+func decoderFromShape(shape []int64, classes int) (string, bool) {
+	if len(shape) != 3 || classes == 0 {
+		return "", false
+	}
+	rows, cols := int(shape[1]), int(shape[2])
+	var fits []string
+	// candidates in rows of box, objectness and class scores
+	if cols == classes+5 {
+		fits = append(fits, config.DecoderTypeYoloV5)
+	}
+	// candidates in columns of box and class scores
+	if rows == classes+4 {
+		fits = append(fits, config.DecoderTypeYoloV8)
+	}
+	// ultralytics end-to-end export, rows of box, score and class
+	if cols == 6 {
+		fits = append(fits, config.DecoderTypeYoloV10)
+	}
+	if len(fits) != 1 {
+		return "", false
+	}
+	return fits[0], true
+}
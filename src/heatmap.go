@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/heatmap"
+)
+
+func heatmapPath(cfg *config.ConfigFile, camera_id string) string {
+	return filepath.Join(cfg.Heatmap.Dir, camera_id+".heatmap")
+}
+
+// Heatmaps of the cameras by id, the saved ones loaded. Empty if
+// heatmaps are disabled
+func newHeatmaps(
+	parent_logger *slog.Logger,
+	cfg *config.ConfigFile,
+	cameras []config.CameraConfig,
+) (map[string]*heatmap.Heatmap, error) {
+	logger := parent_logger.With("coroutine", "heatmap")
+	heatmaps := make(map[string]*heatmap.Heatmap, len(cameras))
+	if !cfg.Heatmap.Enabled {
+		return heatmaps, nil
+	}
+	window := time.Duration(cfg.Heatmap.WindowSec * float64(time.Second))
+	for _, camera := range cameras {
+		h, err := heatmap.New(int(cfg.Heatmap.CellSize), window)
+		if err != nil {
+			return nil, err
+		}
+		heatmaps[camera.Id] = h
+		if cfg.Heatmap.Dir == "" {
+			continue
+		}
+		path := heatmapPath(cfg, camera.Id)
+		if err := h.Load(path); errors.Is(err, fs.ErrNotExist) {
+			logger.Info("No saved heatmap, starting over", "camera", camera.Id, "path", path)
+		} else if err != nil {
+			logger.Warn("Can't load heatmap, starting over", "camera", camera.Id, "path", path, "error", err)
+		} else {
+			logger.Info("Heatmap loaded", "camera", camera.Id, "path", path)
+		}
+	}
+	return heatmaps, nil
+}
+
+// Saves the heatmaps every save period, if there is one, and once
+// more on the way out
+func heatmapSaver(
+	ctx context.Context,
+	parent_logger *slog.Logger,
+	cfg *config.ConfigFile,
+	heatmaps map[string]*heatmap.Heatmap,
+) error {
+	logger := parent_logger.With("coroutine", "heatmap")
+	if len(heatmaps) == 0 || cfg.Heatmap.Dir == "" {
+		logger.Info("Heatmaps are not saved")
+		return nil
+	}
+	save := func() {
+		for camera_id, h := range heatmaps {
+			if err := h.Save(heatmapPath(cfg, camera_id)); err != nil {
+				logger.Error("Can't save heatmap", "camera", camera_id, "error", err)
+			}
+		}
+	}
+	// never fires if only saving on the way out
+	var tick <-chan time.Time
+	if cfg.Heatmap.SavePeriodSec > 0 {
+		ticker := time.NewTicker(time.Second * time.Duration(cfg.Heatmap.SavePeriodSec))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			save()
+			logger.Info("Cancelled by context, heatmaps saved")
+			return context.Canceled
+		case <-tick:
+			save()
+		}
+	}
+}
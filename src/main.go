@@ -4,6 +4,8 @@ import (
 	// stdlib
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -14,7 +16,6 @@ import (
 	// internal
 	"github.com/Robogera/detect/pkg/config"
 	"github.com/Robogera/detect/pkg/indexed"
-	"github.com/Robogera/detect/pkg/person"
 	"github.com/Robogera/detect/pkg/rpath"
 	"gocv.io/x/gocv"
 
@@ -31,6 +32,9 @@ var cfg_path string
 var exe_dir string
 var create_default_config bool
 var migrate_config bool
+var probe_model string
+var probe_backend string
+var probe_target string
 
 func init() {
 	// I have to this or compiler goes crazy on the next line YIKES!
@@ -56,6 +60,14 @@ func init() {
 		&migrate_config, "migrate",
 		false,
 		"Migrate config")
+
+	flag.StringVar(
+		&probe_model, "probe",
+		"",
+		"Internal: check if the model (yolo or reid) runs on -probe-backend and -probe-target and exit")
+
+	flag.StringVar(&probe_backend, "probe-backend", "", "Internal: see -probe")
+	flag.StringVar(&probe_target, "probe-target", "", "Internal: see -probe")
 }
 
 func main() {
@@ -95,6 +107,17 @@ func main() {
 		slog.Error("Config file not loaded. Shutting down...", "provided path", cfg_abs_path, "error", err)
 		return
 	}
+
+	if probe_model != "" {
+		discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+		autoconfigure(discard, cfg)
+		if err := configureReid(discard, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		os.Exit(probe(cfg, probe_model, probe_backend, probe_target))
+	}
+
 	slog.Info("Config file loaded", "provided path", cfg_abs_path)
 
 	var log_level slog.Level
@@ -126,41 +149,79 @@ func main() {
 	ctx := context.Background()
 	eg, child_ctx := errgroup.WithContext(ctx)
 
+	autoconfigure(logger, cfg)
+
+	if err := configureReid(logger, cfg); err != nil {
+		logger.Error("Bad reid config. Shutting down...", "error", err)
+		return
+	}
+
+	if err := selectBackends(ctx, logger, cfg); err != nil {
+		logger.Error("Can't select backends. Shutting down...", "error", err)
+		return
+	}
+
+	cameras, err := cfg.Cameras()
+	if err != nil {
+		logger.Error("Bad camera config. Shutting down...", "error", err)
+		return
+	}
+
+	heatmaps, err := newHeatmaps(logger, cfg, cameras)
+	if err != nil {
+		logger.Error("Bad heatmap config. Shutting down...", "error", err)
+		return
+	}
+
 	// TODO: try buffering
-	unsorted_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8)
-	sorted_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8)
-	ident_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8)
+	ident_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8*len(cameras))
 
 	stat_chan := make(chan Statistics, 8)
 
-	mat_chan := make(chan indexed.Indexed[*gocv.Mat], 8)
+	// shared by all cameras so the detectors are too
+	mat_chan := make(chan indexed.Indexed[*gocv.Mat], 8*len(cameras))
 
-	export_chan := make(chan indexed.Indexed[[]*person.ExportedPerson], 8)
+	export_chan := make(chan indexed.Indexed[Export], 8*len(cameras))
 
-	eg.Go(func() error {
-		return streamreader(child_ctx, logger, cfg, mat_chan)
-	})
+	events_chan := make(chan indexed.Indexed[Events], 8*len(cameras))
+
+	unsorted_frames_chans := make(map[string]chan<- indexed.Indexed[ProcessedFrame], len(cameras))
+
+	for _, camera := range cameras {
+		camera_logger := logger.With("camera", camera.Id)
+		unsorted_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8)
+		sorted_frames_chan := make(chan indexed.Indexed[ProcessedFrame], 8)
+		unsorted_frames_chans[camera.Id] = unsorted_frames_chan
+
+		eg.Go(func() error {
+			return streamreader(child_ctx, logger, camera, mat_chan)
+		})
 
-	for i := 0; i < int(cfg.Yolo.Threads); i++ {
 		eg.Go(func() error {
-			return detector(child_ctx, logger, cfg, mat_chan, unsorted_frames_chan)
+			return sorter(child_ctx, camera_logger, cfg, unsorted_frames_chan, sorted_frames_chan)
+		})
+
+		eg.Go(func() error {
+			return reidentificator(child_ctx, camera_logger, cfg, camera, sorted_frames_chan, ident_frames_chan, export_chan, events_chan, heatmaps[camera.Id])
 		})
 	}
 
-	eg.Go(func() error {
-		return sorter(child_ctx, logger, cfg, unsorted_frames_chan, sorted_frames_chan)
-	})
+	for i := 0; i < int(cfg.Yolo.Threads); i++ {
+		eg.Go(func() error {
+			return detector(child_ctx, logger, cfg, mat_chan, unsorted_frames_chans)
+		})
+	}
 
 	eg.Go(func() error {
-		return reidentificator(child_ctx, logger, cfg, sorted_frames_chan, ident_frames_chan, export_chan)
+		return mqttclient(child_ctx, logger, cfg, export_chan, events_chan)
 	})
 
 	eg.Go(func() error {
-		return mqttclient(child_ctx, logger, cfg, export_chan)
+		return webplayer(child_ctx, logger, cfg, ident_frames_chan, stat_chan, heatmaps)
 	})
 
 	eg.Go(func() error {
-		return webplayer(child_ctx, logger, cfg, ident_frames_chan, stat_chan)
+		return heatmapSaver(child_ctx, logger, cfg, heatmaps)
 	})
 
 	eg.Go(func() error {
@@ -12,15 +12,31 @@ import (
 	"github.com/Robogera/detect/pkg/indexed"
 	"github.com/Robogera/detect/pkg/person"
 	"github.com/Robogera/detect/pkg/synapse"
+	"github.com/Robogera/detect/pkg/zones"
 
 	mqtt "github.com/soypat/natiu-mqtt"
 )
 
+// Tracked people of a frame and the counts of the camera's zones
+// and lines
+type Export struct {
+	People []*person.ExportedPerson
+	Zones  []zones.ZoneCount
+	Lines  []zones.LineCount
+}
+
+// What happened to the tracks of a camera within a frame
+type Events struct {
+	Tracks []*person.ExportedEvent
+	Zones  []*zones.ExportedEvent
+}
+
 func mqttclient(
 	ctx context.Context,
 	parent_logger *slog.Logger,
 	cfg *config.ConfigFile,
-	in_chan <-chan indexed.Indexed[[]*person.ExportedPerson],
+	in_chan <-chan indexed.Indexed[Export],
+	events_chan <-chan indexed.Indexed[Events],
 ) error {
 
 	logger := parent_logger.With("coroutine", "mqttclient")
@@ -81,26 +97,67 @@ func mqttclient(
 		Initiator: cfg.Mqtt.ClientID,
 		Receiver:  cfg.Mqtt.ClientID,
 	}
-	base_vars := mqtt.VariablesPublish{
-		TopicName: []byte(cfg.Mqtt.TopicName),
+	cameras, err := cfg.Cameras()
+	if err != nil {
+		logger.Error("Bad camera config", "error", err)
+		return ERR_INVALID_CONFIG
+	}
+	topics := make(map[string][]byte, len(cameras))
+	for _, camera := range cameras {
+		topics[camera.Id] = []byte(camera.TopicName)
 	}
+	var base_vars mqtt.VariablesPublish
+	// packet ids of the events don't follow the frames
+	var events_id uint16
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Cancelled by context")
 			return context.Canceled
 		case frame := <-in_chan:
+			base_vars.TopicName = topics[frame.Source()]
 			base_vars.PacketIdentifier = uint16(frame.Id() + 1)
-			base_event.Message = &synapse.Message{Parameters: &synapse.Parameters{Detections: frame.Value()}, Subject: cfg.Mqtt.Subject}
+			base_event.Message = &synapse.Message{Parameters: &synapse.Parameters{
+				Camera:     frame.Source(),
+				Detections: frame.Value().People,
+				Zones:      frame.Value().Zones,
+				Lines:      frame.Value().Lines,
+			}, Subject: cfg.Mqtt.Subject}
 			base_event.Id = uint(frame.Id())
 			payload, err := base_event.ToPayload()
 			if err != nil {
-				logger.Error("Can't marshal payload", "frame_id", frame.Id(), "message", frame.Value(), "error", err)
+				logger.Error("Can't marshal payload", "camera", frame.Source(), "frame_id", frame.Id(), "message", frame.Value(), "error", err)
 				return err
 			}
 			err = client.PublishPayload(pub_flags, base_vars, payload)
 			if err != nil {
-				logger.Error("Can't publish", "frame_id", frame.Id(), "payload", string(payload), "error", err)
+				logger.Error("Can't publish", "camera", frame.Source(), "frame_id", frame.Id(), "payload", string(payload), "error", err)
+				return err
+			}
+		case events := <-events_chan:
+			if cfg.Mqtt.EventsTopicName == "" {
+				continue
+			}
+			events_id++
+			vars := mqtt.VariablesPublish{
+				TopicName:        []byte(cfg.Mqtt.EventsTopicName),
+				PacketIdentifier: events_id,
+			}
+			event := *base_event
+			event.Id = uint(events.Id())
+			event.Message = &synapse.Message{Parameters: &synapse.Parameters{
+				Camera:     events.Source(),
+				Events:     events.Value().Tracks,
+				ZoneEvents: events.Value().Zones,
+			}, Subject: cfg.Mqtt.EventsSubject}
+			payload, err := event.ToPayload()
+			if err != nil {
+				logger.Error("Can't marshal events", "camera", events.Source(), "frame_id", events.Id(), "events", events.Value(), "error", err)
+				return err
+			}
+			err = client.PublishPayload(pub_flags, vars, payload)
+			if err != nil {
+				logger.Error("Can't publish events", "camera", events.Source(), "frame_id", events.Id(), "payload", string(payload), "error", err)
 				return err
 			}
 		}
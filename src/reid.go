@@ -4,76 +4,107 @@ import (
 	"context"
 	"fmt"
 	"image"
-
-	// "image/color"
+	"image/color"
 	"log/slog"
 	"runtime"
+	"slices"
 
 	"github.com/Robogera/detect/pkg/config"
+	gocvcommon "github.com/Robogera/detect/pkg/gocv-common"
+	"github.com/Robogera/detect/pkg/heatmap"
+	"github.com/Robogera/detect/pkg/homography"
 	"github.com/Robogera/detect/pkg/indexed"
+	"github.com/Robogera/detect/pkg/mask"
+	"github.com/Robogera/detect/pkg/onnx"
 	"github.com/Robogera/detect/pkg/person"
+	"github.com/Robogera/detect/pkg/yolo"
+	"github.com/Robogera/detect/pkg/zones"
 	"gocv.io/x/gocv"
 )
 
+// Input size of the models that don't say
+var default_reid_input_size = image.Pt(128, 256)
+
 func reidentificator(
 	ctx context.Context,
 	parent_logger *slog.Logger,
 	cfg *config.ConfigFile,
+	camera config.CameraConfig,
 	in_chan <-chan indexed.Indexed[ProcessedFrame],
 	out_chan chan<- indexed.Indexed[ProcessedFrame],
-	export_chan chan<- indexed.Indexed[[]*person.ExportedPerson],
+	export_chan chan<- indexed.Indexed[Export],
+	events_chan chan<- indexed.Indexed[Events],
+	// nil if heatmaps are disabled
+	heat *heatmap.Heatmap,
 ) error {
 	// not sure if this helps
 	runtime.LockOSThread()
 	logger := parent_logger.With("coroutine", "reidentificator")
 
-	var net gocv.Net
-	defer net.Close()
-
-	// TODO: panic and recover when the CGO segfaults maybe?
-	switch config.ModelFormat(cfg.Reid.Format) {
-	case config.ModelFormatCaffe:
-		// TODO: test
-		net = gocv.ReadNetFromCaffe(cfg.Reid.ConfigPath, cfg.Reid.Path)
-	case config.ModelFormatONNX:
-		net = gocv.ReadNetFromONNX(cfg.Reid.Path)
-	case config.ModelFormatOpenVINO:
-		// TODO: test
-		net = gocv.ReadNet(cfg.Reid.Path, cfg.Reid.ConfigPath)
+	classes, err := yolo.NewClasses(cfg)
+	if err != nil {
+		logger.Error("Can't resolve classes", "classes", cfg.Yolo.Classes, "labels", cfg.Yolo.LabelsPath, "error", err)
+		return ERR_INVALID_CONFIG
 	}
 
-	err := net.SetPreferableBackend(gocv.NetBackendOpenVINO)
-	if err != nil {
-		logger.Error("Can't set openvino backend", "model", cfg.Yolo.Path)
-		return ERR_BAD_MODEL
+	// nil for tracking by motion only
+	var embedder person.Embedder
+	if cfg.Reid.Enabled {
+		net, err := gocvcommon.ReadNet(cfg.Reid.Format, cfg.Reid.Path, cfg.Reid.ConfigPath)
+		if err != nil {
+			logger.Error("Error reading network model", "model", cfg.Reid.Path, "error", err)
+			return ERR_BAD_MODEL
+		}
+		defer net.Close()
+
+		backend, err := gocvcommon.ParseBackendTarget(cfg.Reid.Backend, cfg.Reid.Target, cfg.Backend.Device)
+		if err != nil {
+			logger.Error("Bad backend config", "model", cfg.Reid.Path, "error", err)
+			return ERR_INVALID_CONFIG
+		}
+		if err := gocvcommon.SetBackendTarget(&net, backend); err != nil {
+			logger.Error("Can't set backend", "model", cfg.Reid.Path, "backend", backend, "error", err)
+			return ERR_CANT_SET_BACKEND
+		}
+		logger.Info("Model loaded", "model", cfg.Reid.Path, "backend", backend)
+
+		net_embedder, err := person.NewNetEmbedder(&net, cfg)
+		if err != nil {
+			logger.Error("Can't init embedder", "model", cfg.Reid.Path, "error", err)
+			return ERR_BAD_MODEL
+		}
+		embedder = net_embedder
+	} else {
+		logger.Info("Reid disabled, tracking by motion only")
 	}
-	err = net.SetPreferableTarget(gocv.NetTargetCPU)
+
+	associator, err := person.NewAssociator(embedder, cfg, classes)
 	if err != nil {
-		logger.Error("Can't set cpu backend", "model", cfg.Yolo.Path)
-		return ERR_BAD_MODEL
+		logger.Error("Can't init associator", "error", err)
+		return fmt.Errorf("Can't init associator: %w", err)
 	}
 
-	if net.Empty() {
-		logger.Error("Error reading network model")
-		return ERR_BAD_MODEL
+	if len(camera.Calibration.Points) > 0 {
+		h, err := calibrate(camera.Calibration)
+		if err != nil {
+			logger.Error("Bad calibration", "points", camera.Calibration.Points, "error", err)
+			return ERR_INVALID_CONFIG
+		}
+		associator.Calibrate(h)
+		logger.Info("Calibrated, exporting floor positions", "points", len(camera.Calibration.Points))
 	}
-	defer net.Close()
 
-	blob_conv_params := gocv.NewImageToBlobParams(
-		1.0,
-		image.Pt(128, 256),
-		gocv.NewScalar(0, 0, 0, 0),
-		false,
-		gocv.MatTypeCV32F,
-		gocv.DataLayoutNCHW,
-		gocv.PaddingModeLetterbox,
-		gocv.NewScalar(0, 0, 0, 0),
-	)
+	// nil for fill mode masks painted over the frame by streamreader
+	filter, err := mask.FromConfig(camera.Mask)
+	if err != nil {
+		logger.Error("Bad mask", "mask", camera.Mask, "error", err)
+		return ERR_INVALID_CONFIG
+	}
 
-	associator, err := person.NewAssociator(&net, &blob_conv_params, cfg)
+	counter, err := zones.FromConfig(camera.Zone, camera.Line)
 	if err != nil {
-		logger.Error("Can't init associator", "error", err)
-		return fmt.Errorf("Can't init associator: %w", err)
+		logger.Error("Bad zones", "zones", camera.Zone, "lines", camera.Line, "error", err)
+		return ERR_INVALID_CONFIG
 	}
 
 	for {
@@ -84,24 +115,78 @@ func reidentificator(
 		case frame := <-in_chan:
 			dims := frame.Value().Mat.Size()
 			associator.CleanUp(frame.Time(), image.Rect(0, 0, dims[1], dims[0]))
-			associator.Associate(
-				frame.Value().Mat, frame.Value().Boxes, frame.Time(),
-			)
+			detections := frame.Value().Detections
+			if filter != nil {
+				detections = slices.DeleteFunc(detections, func(d yolo.Detection) bool {
+					return !filter.Keep(d.Box)
+				})
+			}
+			if err := associator.Associate(
+				frame.Value().Mat, detections, frame.Time(),
+			); err != nil {
+				logger.Error("Association failure", "camera", frame.Source(), "error", err)
+			}
 			people := associator.EnumeratePeople()
 			status := make(map[string]string, len(people))
-			export := make([]*person.ExportedPerson, 0, len(people))
-			for _, person := range people {
-				export = append(export, person.Export())
-				status[person.Id()] = string(person.Status())
-				if person.IsValid() {
-					person.DrawCross(frame.Value().Mat, 2, 9, 255)
+			exported_people := make([]*person.ExportedPerson, 0, len(people))
+			// where people stand, zones and lines are on the floor. People
+			// that left the frame are counted out of every zone
+			positions := make(map[string]image.Point, len(people))
+			boxes := make(map[string]image.Rectangle, len(people))
+			feet := make([]image.Point, 0, len(people))
+			for _, p := range people {
+				exported_people = append(exported_people, p.Export())
+				status[p.Id()] = string(p.Status())
+				if p.IsValid() && p.Status() != person.STATUS_OOB && p.Status() != person.STATUS_EXPIRED {
+					positions[p.Id()] = p.Foot()
+					boxes[p.Id()] = p.Box()
+					feet = append(feet, p.Foot())
 				}
-				person.DrawBox(frame.Value().Mat, 1)
-				// person.DrawTrajectory(frame.Value().Mat, 1, alpha)
 			}
 			if len(status) > 0 {
 				logger.Info("People", "status", status)
 			}
+			if heat != nil {
+				heat.Add(frame.Time(), image.Pt(dims[1], dims[0]), feet)
+			}
+			zone_events := counter.Update(frame.Time(), positions)
+			for _, exported := range exported_people {
+				dwell := counter.Dwell(exported.Id, frame.Time())
+				if len(dwell) == 0 {
+					continue
+				}
+				exported.ZoneDwellSec = make(map[string]float64, len(dwell))
+				for zone, d := range dwell {
+					exported.ZoneDwellSec[zone] = d.Seconds()
+				}
+			}
+			exported_zone_events := make([]*zones.ExportedEvent, 0, len(zone_events))
+			for _, event := range zone_events {
+				exported := event.Export()
+				// cropped before anything is drawn over the frame
+				if event.Type == zones.EVENT_LOITER {
+					snapshot, err := encodeSnapshot(frame.Value().Mat, boxes[event.Id])
+					if err != nil {
+						logger.Warn("Can't take snapshot", "camera", frame.Source(), "id", event.Id, "error", err)
+					}
+					exported.Snapshot = snapshot
+					logger.Info("Loitering", "camera", frame.Source(), "id", event.Id, "zone", event.Name, "dwell", event.Dwell)
+				}
+				exported_zone_events = append(exported_zone_events, exported)
+			}
+			for _, p := range people {
+				if p.IsValid() {
+					p.DrawCross(frame.Value().Mat, 2, 9, 255)
+				}
+				p.DrawBox(frame.Value().Mat, 1)
+				// p.DrawTrajectory(frame.Value().Mat, 1, alpha)
+			}
+			drawCounter(frame.Value().Mat, counter)
+			export := Export{
+				People: exported_people,
+				Zones:  counter.ZoneCounts(),
+				Lines:  counter.LineCounts(),
+			}
 			select {
 			case <-ctx.Done():
 				logger.Info("Streamreader cancelled by context")
@@ -112,8 +197,130 @@ func reidentificator(
 			case <-ctx.Done():
 				logger.Info("Streamreader cancelled by context")
 				return context.Canceled
-			case export_chan <- indexed.NewIndexed(frame.Id(), frame.Time(), export):
+			case export_chan <- indexed.NewIndexed(frame.Source(), frame.Id(), frame.Time(), export):
+			}
+			track_events := associator.Events()
+			if len(track_events) == 0 && len(zone_events) == 0 {
+				continue
+			}
+			events := Events{
+				Tracks: make([]*person.ExportedEvent, 0, len(track_events)),
+				Zones:  exported_zone_events,
+			}
+			for _, event := range track_events {
+				events.Tracks = append(events.Tracks, event.Export())
+			}
+			select {
+			case <-ctx.Done():
+				logger.Info("Streamreader cancelled by context")
+				return context.Canceled
+			case events_chan <- indexed.NewIndexed(frame.Source(), frame.Id(), frame.Time(), events):
 			}
 		}
 	}
 }
+
+// Outlines the zones and lines and writes their counts next to them
+func drawCounter(m *gocv.Mat, counter *zones.Counter) {
+	zone_color := color.RGBA{255, 255, 0, 255}
+	line_color := color.RGBA{0, 255, 255, 255}
+	for i, count := range counter.ZoneCounts() {
+		polygon := counter.Zones()[i].Polygon
+		outline := gocv.NewPointsVectorFromPoints([][]image.Point{polygon})
+		gocv.Polylines(m, outline, true, zone_color, 2)
+		outline.Close()
+		gocv.PutText(m, fmt.Sprintf("%s: %d in:%d out:%d", count.Name, count.Occupancy, count.In, count.Out),
+			polygon[0].Add(image.Pt(4, 16)), gocv.FontHersheyPlain, 1, zone_color, 1)
+	}
+	for i, count := range counter.LineCounts() {
+		line := counter.Lines()[i]
+		gocv.Line(m, line.Start, line.End, line_color, 2)
+		gocv.PutText(m, fmt.Sprintf("%s: a>b:%d b>a:%d", count.Name, count.AToB, count.BToA),
+			line.Start.Add(image.Pt(4, -4)), gocv.FontHersheyPlain, 1, line_color, 1)
+	}
+}
+
+// JPEG of the part of m inside box
+func encodeSnapshot(m *gocv.Mat, box image.Rectangle) ([]byte, error) {
+	dims := m.Size()
+	crop := box.Intersect(image.Rect(0, 0, dims[1], dims[0]))
+	if crop.Empty() {
+		return nil, fmt.Errorf("Box %v is outside the frame", box)
+	}
+	region := m.Region(crop)
+	defer region.Close()
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, region)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	data := make([]byte, buf.Len())
+	copy(data, buf.GetBytes())
+	return data, nil
+}
+
+// Homography from the image to the floor plane
+func calibrate(calibration config.CalibrationConfig) (*homography.Homography, error) {
+	image_points := make([]homography.Point, 0, len(calibration.Points))
+	world_points := make([]homography.Point, 0, len(calibration.Points))
+	for _, point := range calibration.Points {
+		image_points = append(image_points, homography.Point{X: float64(point.Image.X), Y: float64(point.Image.Y)})
+		world_points = append(world_points, homography.Point{X: point.World.X, Y: point.World.Y})
+	}
+	return homography.Estimate(image_points, world_points)
+}
+
+// Fills the reid input size left empty in the config from the onnx
+// model and checks the preprocessing against the model's input.
+// Has to run before the reidentificators start
+func configureReid(parent_logger *slog.Logger, cfg *config.ConfigFile) error {
+	logger := parent_logger.With("coroutine", "reidentificator")
+
+	if !cfg.Reid.Enabled {
+		return nil
+	}
+
+	if config.ModelFormat(cfg.Reid.Format) == config.ModelFormatONNX {
+		model, err := onnx.Read(cfg.Reid.Path)
+		if err != nil {
+			logger.Warn("Can't read model metadata", "model", cfg.Reid.Path, "error", err)
+		} else if len(model.Inputs) > 0 {
+			// NCHW
+			shape := model.Inputs[0].Shape
+			if len(shape) != 4 {
+				return fmt.Errorf("Reid model %s has input of shape %v, expected NCHW", cfg.Reid.Path, shape)
+			}
+			if shape[1] > 0 && shape[1] != 3 {
+				return fmt.Errorf("Reid model %s expects %d channels, only 3 are supported", cfg.Reid.Path, shape[1])
+			}
+			if cfg.Reid.W == 0 && shape[3] > 0 {
+				cfg.Reid.W = uint(shape[3])
+			}
+			if cfg.Reid.H == 0 && shape[2] > 0 {
+				cfg.Reid.H = uint(shape[2])
+			}
+			if (shape[3] > 0 && int64(cfg.Reid.W) != shape[3]) || (shape[2] > 0 && int64(cfg.Reid.H) != shape[2]) {
+				return fmt.Errorf("Reid input %dx%d doesn't match model %s input %dx%d",
+					cfg.Reid.W, cfg.Reid.H, cfg.Reid.Path, shape[3], shape[2])
+			}
+			if shape[0] > 1 && cfg.Reid.BatchSize != uint(shape[0]) {
+				logger.Warn("Model has a fixed batch size", "model", cfg.Reid.Path,
+					"model batch size", shape[0], "batch_size", cfg.Reid.BatchSize)
+			} else if shape[0] == 1 && cfg.Reid.BatchSize != 1 {
+				cfg.Reid.BatchSize = 1
+				logger.Warn("Model has a fixed batch size of 1, batching disabled", "model", cfg.Reid.Path)
+			}
+		}
+	}
+
+	if cfg.Reid.W == 0 || cfg.Reid.H == 0 {
+		cfg.Reid.W, cfg.Reid.H = uint(default_reid_input_size.X), uint(default_reid_input_size.Y)
+		logger.Warn("Unknown reid input size, using the default", "w", cfg.Reid.W, "h", cfg.Reid.H)
+	}
+	if _, _, err := person.BlobParams(cfg); err != nil {
+		return err
+	}
+	logger.Debug("Reid preprocessing", "w", cfg.Reid.W, "h", cfg.Reid.H, "scale_factor", cfg.Reid.ScaleFactor,
+		"mean", cfg.Reid.Mean, "std", cfg.Reid.Std, "swap_rb", cfg.Reid.SwapRB, "padding", cfg.Reid.Padding)
+	return nil
+}
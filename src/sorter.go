@@ -7,8 +7,8 @@ import (
 	"time"
 
 	"github.com/Robogera/detect/pkg/config"
-	"github.com/Robogera/detect/pkg/gheap"
 	"github.com/Robogera/detect/pkg/indexed"
+	"github.com/Robogera/detect/pkg/reorder"
 )
 
 func sorter(
@@ -24,15 +24,21 @@ func sorter(
 
 	logger := parent_logger.With("coroutine", "sorter")
 
-	queue := gheap.Heap[indexed.Indexed[ProcessedFrame]]{}
-	queue.Init()
+	queue := reorder.NewBuffer[ProcessedFrame](
+		time.Duration(cfg.Sorter.MaxLatencyMs)*time.Millisecond,
+		cfg.Sorter.Capacity,
+	)
 
-	// TODO: move to config
-	// OR calculate the moving average of the incomig frametime and adjust
-	// the ticker period based on it
-	ticker := time.NewTicker(time.Second / time.Duration(cfg.Yolo.SortingFPS))
+	// fires when the oldest buffered frame runs out of its latency budget
+	deadline := time.NewTimer(0)
+	defer deadline.Stop()
 
-	var expected_frame uint64 = 0
+	var stat_ticker <-chan time.Time
+	if cfg.Logging.StatPeriodSec > 0 {
+		ticker := time.NewTicker(time.Second * time.Duration(cfg.Logging.StatPeriodSec))
+		defer ticker.Stop()
+		stat_ticker = ticker.C
+	}
 
 	for {
 		select {
@@ -40,28 +46,39 @@ func sorter(
 			logger.Info("Cancelled by context")
 			return context.Canceled
 		case frame := <-unsorted_frames_chan:
-			if frame.Id() < expected_frame {
-        logger.Warn("Bad index", "expected", expected_frame, "got", frame.Id())
-        frame.Value().Mat.Close()
+			if !queue.Push(frame) {
+				logger.Warn("Stale frame dropped", "expected", queue.Expected(), "got", frame.Id())
+				frame.Value().Mat.Close()
 				continue
 			}
-			queue.Push(frame)
-		case <-ticker.C:
-			if queue.IsEmpty() {
-				continue
+		case <-deadline.C:
+		case <-stat_ticker:
+			logger.Info("Sorter", "queue", queue.Len(), "gaps", queue.Gaps(), "stale", queue.Stale())
+			continue
+		}
+
+		for {
+			expected := queue.Expected()
+			frame, ok := queue.Pop(time.Now())
+			if !ok {
+				break
 			}
-			if queue.Peek().Id() > expected_frame {			
-				continue
+			if frame.Id() != expected {
+				logger.Warn("Frames skipped", "from", expected, "to", frame.Id()-1)
 			}
-			frame := queue.Pop()
 			select {
 			case <-ctx.Done():
 				logger.Info("Cancelled by context")
 				return context.Canceled
 			case sorted_frames_chan <- frame:
-        logger.Debug("Queue", "len", queue.Len())
-				expected_frame = frame.Id() + 1
+				logger.Debug("Queue", "len", queue.Len())
 			}
 		}
+
+		if t, ok := queue.Deadline(); ok {
+			deadline.Reset(time.Until(t))
+		} else {
+			deadline.Stop()
+		}
 	}
 }
@@ -11,6 +11,7 @@ import (
 
 // WIP
 type Statistics struct {
+	camera         string
 	inference_time time.Duration
 }
 
@@ -21,21 +22,42 @@ func stat(
 	cfg *config.ConfigFile,
 	stat_chan <-chan Statistics,
 ) error {
-	sma, err := gsma.NewSMA[float64](100)
 	logger := parent_logger.With("coroutine", "stat")
-	if err != nil {
-		logger.Error("Can't init an SMA accumulator", "error", err)
-	}
+	// frame time accumulators keyed by camera id
+	smas := make(map[string]*gsma.SMA[float64])
 	ticker := time.NewTicker(time.Second * time.Duration(cfg.Logging.StatPeriodSec))
+	yolo_backend := cfg.Yolo.Backend + "/" + cfg.Yolo.Target
+	if config.DetectorType(cfg.Yolo.Detector) == config.DetectorTypeReplay {
+		yolo_backend = "replay"
+	}
+	reid_backend := cfg.Reid.Backend + "/" + cfg.Reid.Target
+	if !cfg.Reid.Enabled {
+		reid_backend = "disabled"
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Cancelled by context")
 			return context.Canceled
 		case stats := <-stat_chan:
+			sma, ok := smas[stats.camera]
+			if !ok {
+				var err error
+				sma, err = gsma.NewSMA[float64](100)
+				if err != nil {
+					logger.Error("Can't init an SMA accumulator", "error", err)
+					return err
+				}
+				smas[stats.camera] = sma
+			}
 			sma.Recalc(stats.inference_time.Seconds())
 		case <-ticker.C:
-			logger.Info("Performance", "frame time SMA (sec)", sma.Show(), "avg FPS", 1.0/sma.Show())
+			logger.Info("Backends",
+				"yolo", yolo_backend,
+				"reid", reid_backend)
+			for camera, sma := range smas {
+				logger.Info("Performance", "camera", camera, "frame time SMA (sec)", sma.Show(), "avg FPS", 1.0/sma.Show())
+			}
 		}
 	}
 }
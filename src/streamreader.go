@@ -19,26 +19,28 @@ import (
 	"gocv.io/x/gocv"
 )
 
-var frame_id uint64 = 0
-
 func streamreader(
 	ctx context.Context,
 	parent_logger *slog.Logger,
-	cfg *config.ConfigFile,
+	camera config.CameraConfig,
 	mat_chan chan<- indexed.Indexed[*gocv.Mat],
 ) error {
 
 	// not sure if this helps
 	runtime.LockOSThread()
 
-	logger := parent_logger.With("coroutine", "streamreader")
+	logger := parent_logger.With("coroutine", "streamreader", "camera", camera.Id)
+
+	// survives restarts so the sorter never sees an index twice
+	var frame_id uint64 = 0
+
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("Streamreader cancelled by context")
 			return context.Canceled
 		default:
-			err := _streamreader(ctx, logger, cfg, mat_chan)
+			err := _streamreader(ctx, logger, camera, &frame_id, mat_chan)
 			if errors.Is(err, context.Canceled) {
 				return err
 			} else {
@@ -51,32 +53,33 @@ func streamreader(
 func _streamreader(
 	ctx context.Context,
 	logger *slog.Logger,
-	cfg *config.ConfigFile,
+	camera config.CameraConfig,
+	frame_id *uint64,
 	mat_chan chan<- indexed.Indexed[*gocv.Mat],
 ) error {
 	var input_stream *gocv.VideoCapture
 	var err error
 
-	switch config.InputType(cfg.Input.Type) {
+	switch config.InputType(camera.Input.Type) {
 	case config.InputTypeFile:
-		input_stream, err = gocv.VideoCaptureFile(cfg.Input.Path)
+		input_stream, err = gocv.VideoCaptureFile(camera.Input.Path)
 	case config.InputTypeWebcam:
 		// TODO: implement user supplied index/device address
 		input_stream, err = gocv.VideoCaptureDevice(0)
 	case config.InputTypeIPC:
-		input_stream, err = gocv.OpenVideoCapture(cfg.Input.Path)
+		input_stream, err = gocv.OpenVideoCapture(camera.Input.Path)
 	default:
 		slog.Error(
 			"No valid input type provided. Shutting down...",
-			"provided value", cfg.Input.Type)
+			"provided value", camera.Input.Type)
 		return ERR_INVALID_CONFIG
 	}
 
 	if err != nil {
 		logger.Error(
 			"Can't open input",
-			"type", cfg.Input.Type,
-			"address", cfg.Input.Path,
+			"type", camera.Input.Type,
+			"address", camera.Input.Path,
 			"err", err)
 		return ERR_BAD_INPUT
 	}
@@ -85,9 +88,10 @@ func _streamreader(
 	var fill_zone gocv.PointsVector
 	var do_fill bool
 
-	if len(cfg.Mask.Contours) > 0 {
-		contours := make([][]image.Point, 0, len(cfg.Mask.Contours))
-		for _, points := range cfg.Mask.Contours {
+	// filter mode masks drop detections in reidentificator instead
+	if len(camera.Mask.Contours) > 0 && camera.Mask.Mode != config.MaskModeFilter {
+		contours := make([][]image.Point, 0, len(camera.Mask.Contours))
+		for _, points := range camera.Mask.Contours {
 			contour := make([]image.Point, 0, len(points))
 			for _, point := range points {
 				contour = append(contour, image.Pt(int(point.X), int(point.Y)))
@@ -101,14 +105,14 @@ func _streamreader(
 
 	var crop_zone image.Rectangle
 	var do_crop bool
-	if cfg.Crop.A.X != 0 || cfg.Crop.A.Y != 0 ||
-		cfg.Crop.B.X != 0 || cfg.Crop.B.Y != 0 {
+	if camera.Crop.A.X != 0 || camera.Crop.A.Y != 0 ||
+		camera.Crop.B.X != 0 || camera.Crop.B.Y != 0 {
 		do_crop = true
 		crop_zone = image.Rect(
-			int(cfg.Crop.A.X),
-			int(cfg.Crop.A.Y),
-			int(cfg.Crop.B.X),
-			int(cfg.Crop.B.Y),
+			int(camera.Crop.A.X),
+			int(camera.Crop.A.Y),
+			int(camera.Crop.B.X),
+			int(camera.Crop.B.Y),
 		)
 	}
 
@@ -124,12 +128,12 @@ func _streamreader(
 				img := gocv.NewMat()
 				defer img.Close()
 				if !input_stream.Read(&img) {
-					logger.Error("Can't read next frame. Shutting down...", "stream", cfg.Input.Path)
+					logger.Error("Can't read next frame. Shutting down...", "stream", camera.Input.Path)
 					img.Close()
 					return ERR_STREAM_ENDED
 				}
 				if img.Empty() {
-					logger.Error("Empty frame received, skipping", "stream", cfg.Input.Path)
+					logger.Error("Empty frame received, skipping", "stream", camera.Input.Path)
 					img.Close()
 					return nil
 				}
@@ -143,7 +147,7 @@ func _streamreader(
 					}
 					if crop_zone.Dx() < 1 || crop_zone.Dy() < 1 {
 						logger.Error("crop zone too small", "crop_zone", crop_zone)
-            return fmt.Errorf("Crop zone too small")
+						return fmt.Errorf("Crop zone too small")
 					}
 					region_ptr := img.Region(crop_zone)
 					defer region_ptr.Close()
@@ -154,7 +158,7 @@ func _streamreader(
 
 				if do_fill {
 					gocv.FillPoly(&processed_img, fill_zone, color.RGBA{
-						cfg.Mask.Color.R, cfg.Mask.Color.G, cfg.Mask.Color.B, 255})
+						camera.Mask.Color.R, camera.Mask.Color.G, camera.Mask.Color.B, 255})
 				}
 
 				return nil
@@ -167,8 +171,8 @@ func _streamreader(
 			case <-ctx.Done():
 				logger.Info("Cancelled by context")
 				return context.Canceled
-			case mat_chan <- indexed.NewIndexed(frame_id, time.Now(), &processed_img):
-				frame_id++
+			case mat_chan <- indexed.NewIndexed(camera.Id, *frame_id, time.Now(), &processed_img):
+				*frame_id++
 			}
 		}
 	}
@@ -4,14 +4,19 @@ import (
 	// stdlib
 	"context"
 	"fmt"
+	"html"
 	"image"
+	"image/png"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"runtime"
+	"strings"
 	"time"
 
 	// internal
 	"github.com/Robogera/detect/pkg/config"
+	"github.com/Robogera/detect/pkg/heatmap"
 	"github.com/Robogera/detect/pkg/indexed"
 	"gocv.io/x/gocv"
 
@@ -26,6 +31,7 @@ func webplayer(
 	cfg *config.ConfigFile, // wish I could pass this as read only to prevent subroutines messing the configuration or data races...
 	in_chan <-chan indexed.Indexed[ProcessedFrame],
 	stat_chan chan<- Statistics,
+	heatmaps map[string]*heatmap.Heatmap,
 ) error {
 
 	// not sure if this helps
@@ -33,14 +39,48 @@ func webplayer(
 
 	logger := parent_logger.With("coroutine", "webplayer")
 
-	output_stream := mjpeg.NewStream()
+	cameras, err := cfg.Cameras()
+	if err != nil {
+		logger.Error("Bad camera config", "error", err)
+		return ERR_INVALID_CONFIG
+	}
 
-	http.HandleFunc("/mjpeg", output_stream.ServeHTTP)
+	output_streams := make(map[string]*mjpeg.Stream, len(cameras))
+	for _, camera := range cameras {
+		output_streams[camera.Id] = mjpeg.NewStream()
+		http.HandleFunc("/mjpeg/"+camera.Id, output_streams[camera.Id].ServeHTTP)
+	}
+	// kept for the single camera setups
+	http.HandleFunc("/mjpeg", output_streams[cameras[0].Id].ServeHTTP)
+
+	if h, ok := heatmaps[cameras[0].Id]; ok {
+		// one pattern for every camera so ids never end up in patterns
+		http.HandleFunc("/heatmap/{file}", func(w http.ResponseWriter, r *http.Request) {
+			camera_id, is_png := strings.CutSuffix(r.PathValue("file"), ".png")
+			h, found := heatmaps[camera_id]
+			if !is_png || !found {
+				http.NotFound(w, r)
+				return
+			}
+			serveHeatmap(logger, cfg, h)(w, r)
+		})
+		// kept for the single camera setups
+		http.HandleFunc("/heatmap.png", serveHeatmap(logger, cfg, h))
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.Write([]byte(`<meta http-equiv="refresh" content="3" />`))
-		w.Write([]byte(`<img src="/mjpeg" style="width: 95%" />`))
+		for _, camera := range cameras {
+			w.Write([]byte(fmt.Sprintf(`<p>%s</p>`, html.EscapeString(camera.Id))))
+			w.Write([]byte(`<div style="position: relative">`))
+			w.Write([]byte(fmt.Sprintf(`<img src="/mjpeg/%s" style="width: 95%%" />`, url.PathEscape(camera.Id))))
+			if _, ok := heatmaps[camera.Id]; ok {
+				w.Write([]byte(fmt.Sprintf(`<img src="/heatmap/%s.png" style="width: 95%%; position: absolute; top: 0; left: 0" />`,
+					url.PathEscape(camera.Id))))
+			}
+			w.Write([]byte(`</div>`))
+		}
 	})
 
 	server := &http.Server{
@@ -69,7 +109,10 @@ func webplayer(
 
 	logger.Info("Started", "port", cfg.Webserver.Port)
 
-	last_frame_timestamp := time.Now()
+	last_frame_timestamps := make(map[string]time.Time, len(cameras))
+	for _, camera := range cameras {
+		last_frame_timestamps[camera.Id] = time.Now()
+	}
 
 	for {
 		select {
@@ -81,6 +124,12 @@ func webplayer(
 			logger.Error("Error", "port", cfg.Webserver.Port, "error", err)
 			return err
 		case frame := <-in_chan:
+			output_stream, ok := output_streams[frame.Source()]
+			if !ok {
+				logger.Error("Frame from unknown camera", "camera", frame.Source())
+				frame.Value().Mat.Close()
+				continue
+			}
 			if cfg.Webserver.W != 0 && cfg.Webserver.H != 0 {
 				gocv.Resize(*frame.Value().Mat, frame.Value().Mat, image.Pt(int(cfg.Webserver.W), int(cfg.Webserver.H)), 1, 1, gocv.InterpolationLinear)
 			}
@@ -95,8 +144,8 @@ func webplayer(
 			buf.Close()
 			frame.Value().Mat.Close()
 			select {
-			case stat_chan <- Statistics{time.Since(last_frame_timestamp)}:
-				last_frame_timestamp = time.Now()
+			case stat_chan <- Statistics{frame.Source(), time.Since(last_frame_timestamps[frame.Source()])}:
+				last_frame_timestamps[frame.Source()] = time.Now()
 			case <-ctx.Done():
 				logger.Info("Cancelled by context")
 				return context.Canceled
@@ -104,3 +153,22 @@ func webplayer(
 		}
 	}
 }
+
+// Serves the heatmap as a PNG of the size of the served frames
+func serveHeatmap(logger *slog.Logger, cfg *config.ConfigFile, h *heatmap.Heatmap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := h.Size()
+		if cfg.Webserver.W != 0 && cfg.Webserver.H != 0 {
+			size = image.Pt(int(cfg.Webserver.W), int(cfg.Webserver.H))
+		}
+		if size.X == 0 || size.Y == 0 {
+			http.Error(w, "No frames yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		if err := png.Encode(w, h.Image(size)); err != nil {
+			logger.Warn("Can't send heatmap", "error", err)
+		}
+	}
+}